@@ -122,6 +122,10 @@ func SubscribeBinlogEventsOnBootstrapV3(opt SubscribeBinlogOptionV3) {
 				EventTypes: slutil.SliceCopy(pipe.EventTypes),
 				Stream:     opt.MergedPipeline.Stream,
 				Condition:  pipe.Condition,
+
+				Transactional: pipe.Transactional,
+				Format:        pipe.Format,
+				Source:        pipe.Source,
 			})
 			if err != nil && opt.ContinueOnErr {
 				rail.Errorf("failed to create pipeline, %#v, %v", pipe, err)
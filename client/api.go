@@ -13,13 +13,30 @@ const (
 
 	// row deleted
 	EventTypeDelete = "DEL"
+
+	// table schema changed, e.g., ALTER TABLE, it must be subscribed explicitly
+	EventTypeDDL = "DDL"
+
+	// row read from the table in snapshot
+	EventTypeSnapshot = "SNAP"
+)
+
+const (
+	// column values are formatted as strings, see StreamEvent
+	FormatV1 = "v1"
+
+	// column values are typed json values, see StreamEventV2
+	FormatV2 = "v2"
 )
 
 type MPipeline struct {
-	Schema     string      // schema name
-	Table      string      // table name
-	EventTypes []EventType // event types subscribed
-	Condition  Condition   // extra binlog filtering condition
+	Schema        string      // schema name
+	Table         string      // table name
+	EventTypes    []EventType // event types subscribed
+	Condition     Condition   // extra binlog filtering condition
+	Transactional bool        // publish one TxStreamEvent per committed transaction
+	Format        string      // event format, FormatV1 (default) or FormatV2
+	Source        string      // name of the source, if empty, events from all sources are subscribed
 }
 
 type MergedPipeline struct {
@@ -28,11 +45,14 @@ type MergedPipeline struct {
 }
 
 type Pipeline struct {
-	Schema     string      // schema name
-	Table      string      // table name
-	EventTypes []EventType // event types subscribed
-	Stream     string      // miso event bus name
-	Condition  Condition   // extra binlog filtering condition
+	Schema        string      // schema name
+	Table         string      // table name
+	EventTypes    []EventType // event types subscribed
+	Stream        string      // miso event bus name
+	Condition     Condition   // extra binlog filtering condition
+	Transactional bool        // publish one TxStreamEvent per committed transaction
+	Format        string      // event format, FormatV1 (default) or FormatV2
+	Source        string      // name of the source, if empty, events from all sources are subscribed
 }
 
 type Condition struct {
@@ -1,17 +1,60 @@
 package client
 
+import "encoding/json"
+
 type StreamEvent struct {
+	Source    string                 `json:"source"`    // name of the source
 	Timestamp uint32                 `json:"timestamp"` // epoch time second
 	Schema    string                 `json:"schema"`
 	Table     string                 `json:"table"`
-	Type      string                 `json:"type"`    // INS-INSERT, UPD-UPDATE, DEL-DELETE
-	Columns   map[string]EventColumn `json:"columns"` // key is the column name
+	Type      string                 `json:"type"`          // INS-INSERT, UPD-UPDATE, DEL-DELETE, DDL-DDL, SNAP-SNAPSHOT
+	Columns   map[string]EventColumn `json:"columns"`       // key is the column name
+	Key       map[string]string      `json:"key,omitempty"` // primary key columns and values
+	DDL       *EventDDL              `json:"ddl,omitempty"` // only for DDL event
+	TxId      string                 `json:"txId"`          // transaction id, GTID if available, otherwise the binlog position of the beginning of the transaction
+	TxSeq     int                    `json:"txSeq"`         // sequence of the record within the transaction
+
+	EventId    string `json:"eventId"`        // deterministic event id, it can be used as idempotency key
+	BinlogFile string `json:"binlogFile"`     // binlog file name
+	BinlogPos  uint32 `json:"binlogPos"`      // start position of the binlog event
+	ServerId   uint32 `json:"serverId"`       // server id of the instance that wrote the binlog event
+	GTID       string `json:"gtid,omitempty"` // GTID of the transaction, if available
+	RowIndex   int    `json:"rowIndex"`       // index of the row within the binlog event
+}
+
+// Events of a committed transaction, published by transactional pipelines.
+type TxStreamEvent struct {
+	Source    string        `json:"source"` // name of the source
+	TxId      string        `json:"txId"`
+	Timestamp uint32        `json:"timestamp"` // epoch time second
+	Events    []StreamEvent `json:"events"`
+}
+
+type EventDDL struct {
+	Statement  string           `json:"statement"`  // DDL statement
+	OldColumns []EventColumnDef `json:"oldColumns"` // columns before the DDL
+	NewColumns []EventColumnDef `json:"newColumns"` // columns after the DDL
+}
+
+type EventColumnDef struct {
+	Name       string `json:"name"`
+	DataType   string `json:"dataType"`
+	Unsigned   bool   `json:"unsigned"`
+	PrimaryKey bool   `json:"primaryKey"`
 }
 
 type EventColumn struct {
 	DataType string `json:"dataType"`
 	Before   string `json:"before"`
 	After    string `json:"after"`
+
+	BeforeNull bool `json:"beforeNull"` // Before value is NULL, Before is '<nil>' when it's NULL
+	AfterNull  bool `json:"afterNull"`  // After value is NULL, After is '<nil>' when it's NULL
+
+	BeforeAbsent bool `json:"beforeAbsent"` // column is not present in the before image (binlog_row_image=MINIMAL/NOBLOB)
+	AfterAbsent  bool `json:"afterAbsent"`  // column is not present in the after image (binlog_row_image=MINIMAL/NOBLOB)
+
+	JsonPatch []JsonPatchOp `json:"jsonPatch"` // partial JSON update that can't be applied to the before image, After is absent
 }
 
 // Get Column's After value.
@@ -22,3 +65,90 @@ func (b *StreamEvent) ColumnAfter(name string) (string, bool) {
 	}
 	return v.After, true
 }
+
+// Event with typed column values, published by pipelines using FormatV2.
+type StreamEventV2 struct {
+	Source    string                     `json:"source"`    // name of the source
+	Version   int                        `json:"version"`   // always 2
+	Timestamp uint32                     `json:"timestamp"` // epoch time second
+	Schema    string                     `json:"schema"`
+	Table     string                     `json:"table"`
+	Type      string                     `json:"type"`          // INS-INSERT, UPD-UPDATE, DEL-DELETE, DDL-DDL, SNAP-SNAPSHOT
+	Columns   map[string]EventColumnV2   `json:"columns"`       // key is the column name
+	Key       map[string]json.RawMessage `json:"key,omitempty"` // primary key columns and values
+	DDL       *EventDDL                  `json:"ddl,omitempty"` // only for DDL event
+	TxId      string                     `json:"txId"`
+	TxSeq     int                        `json:"txSeq"`
+
+	EventId    string `json:"eventId"`        // deterministic event id, it can be used as idempotency key
+	BinlogFile string `json:"binlogFile"`     // binlog file name
+	BinlogPos  uint32 `json:"binlogPos"`      // start position of the binlog event
+	ServerId   uint32 `json:"serverId"`       // server id of the instance that wrote the binlog event
+	GTID       string `json:"gtid,omitempty"` // GTID of the transaction, if available
+	RowIndex   int    `json:"rowIndex"`       // index of the row within the binlog event
+}
+
+// Events of a committed transaction, published by transactional pipelines using FormatV2.
+type TxStreamEventV2 struct {
+	Source    string          `json:"source"` // name of the source
+	TxId      string          `json:"txId"`
+	Timestamp uint32          `json:"timestamp"` // epoch time second
+	Events    []StreamEventV2 `json:"events"`
+}
+
+// Column with typed values.
+//
+// Numbers are json numbers, strings are json strings, binary values are base64 encoded strings,
+// json columns are embedded as json, NULL is json null, date/time values are ISO 8601 strings.
+type EventColumnV2 struct {
+	DataType string          `json:"dataType"`
+	Before   json.RawMessage `json:"before"`
+	After    json.RawMessage `json:"after"`
+
+	BeforeAbsent bool `json:"beforeAbsent"` // column is not present in the before image (binlog_row_image=MINIMAL/NOBLOB)
+	AfterAbsent  bool `json:"afterAbsent"`  // column is not present in the after image (binlog_row_image=MINIMAL/NOBLOB)
+
+	JsonPatch []JsonPatchOp `json:"jsonPatch"` // partial JSON update that can't be applied to the before image, After is absent
+}
+
+// Whether the After value is NULL.
+func (c EventColumnV2) AfterIsNull() bool {
+	return isJsonNull(c.After)
+}
+
+// Whether the Before value is NULL.
+func (c EventColumnV2) BeforeIsNull() bool {
+	return isJsonNull(c.Before)
+}
+
+// Unmarshal the After value.
+func (c EventColumnV2) UnmarshalAfter(v any) error {
+	return json.Unmarshal(c.After, v)
+}
+
+// Unmarshal the Before value.
+func (c EventColumnV2) UnmarshalBefore(v any) error {
+	return json.Unmarshal(c.Before, v)
+}
+
+// Get Column's After value.
+func (b *StreamEventV2) ColumnAfter(name string) (json.RawMessage, bool) {
+	v, ok := b.Columns[name]
+	if !ok {
+		return nil, false
+	}
+	return v.After, true
+}
+
+func isJsonNull(v json.RawMessage) bool {
+	return len(v) < 1 || string(v) == "null"
+}
+
+// JSON patch operation of partial JSON update (binlog_row_value_options=PARTIAL_JSON).
+//
+// Op is one of 'replace', 'insert' and 'remove', Path is MySQL JSON path, e.g., '$.name', '$.items[0]'.
+type JsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
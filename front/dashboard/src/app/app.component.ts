@@ -12,9 +12,12 @@ import { environment } from '../environments/environment';
 export interface ApiPipeline {
   schema?: string; // schema name
   table?: string; // table name
-  eventTypes?: string[]; // event types; INS - Insert, UPD - Update, DEL - Delete
+  eventTypes?: string[]; // event types; INS - Insert, UPD - Update, DEL - Delete, DDL - Schema Change, SNAP - Snapshot
   stream?: string; // event bus name
   condition?: Condition;
+  transactional?: boolean; // publish one message per committed transaction that contains all the row changes
+  format?: string; // event format; v1 (default) - column values as strings, v2 - typed column values
+  source?: string; // name of the source; if empty, events from all sources are subscribed
 }
 
 export interface Condition {
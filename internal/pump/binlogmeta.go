@@ -0,0 +1,133 @@
+package pump
+
+import (
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	// collation id of 'binary', used to tell BLOB from TEXT, BINARY from CHAR, etc.
+	binaryCollationId = 63
+)
+
+// Build TableInfo using the optional metadata in TableMapEvent.
+//
+// The metadata is only available when `binlog_row_metadata=FULL` (MySQL >= 8.0.1, MariaDB >= 10.5.0),
+// if the column names are missing, false is returned, and the caller should fallback to information_schema.
+//
+// https://dev.mysql.com/doc/refman/8.0/en/replication-options-binary-log.html#sysvar_binlog_row_metadata
+func tableInfoFromTableMap(tme *replication.TableMapEvent) (TableInfo, bool) {
+	if tme == nil {
+		return TableInfo{}, false
+	}
+	names := tme.ColumnNameString()
+	if len(names) < 1 || len(names) != int(tme.ColumnCount) {
+		return TableInfo{}, false
+	}
+
+	unsigned := tme.UnsignedMap()
+	collations := tme.CollationMap()
+	enumValues := tme.EnumStrValueMap()
+	setValues := tme.SetStrValueMap()
+	pks := make(map[int]bool, len(tme.PrimaryKey))
+	for _, i := range tme.PrimaryKey {
+		pks[int(i)] = true
+	}
+
+	columns := make([]ColumnInfo, 0, len(names))
+	for i, n := range names {
+		columns = append(columns, ColumnInfo{
+			ColumnName:      n,
+			DataType:        binlogColumnDataType(tme, i, collations),
+			OrdinalPosition: i + 1,
+			Unsigned:        unsigned[i],
+			PrimaryKey:      pks[i],
+			EnumValues:      enumValues[i],
+			SetValues:       setValues[i],
+		})
+	}
+
+	return TableInfo{
+		Schema:  string(tme.Schema),
+		Table:   string(tme.Table),
+		Columns: columns,
+	}, true
+}
+
+// Resolve the data type name of the i-th column, the name is the same as the DATA_TYPE in information_schema.columns.
+func binlogColumnDataType(tme *replication.TableMapEvent, i int, collations map[int]uint64) string {
+	if tme.IsEnumColumn(i) {
+		return "enum"
+	}
+	if tme.IsSetColumn(i) {
+		return "set"
+	}
+
+	binary := false
+	if c, ok := collations[i]; ok {
+		binary = c == binaryCollationId
+	}
+
+	switch tme.ColumnType[i] {
+	case mysql.MYSQL_TYPE_DECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL:
+		return "decimal"
+	case mysql.MYSQL_TYPE_TINY:
+		return "tinyint"
+	case mysql.MYSQL_TYPE_SHORT:
+		return "smallint"
+	case mysql.MYSQL_TYPE_INT24:
+		return "mediumint"
+	case mysql.MYSQL_TYPE_LONG:
+		return "int"
+	case mysql.MYSQL_TYPE_LONGLONG:
+		return "bigint"
+	case mysql.MYSQL_TYPE_FLOAT:
+		return "float"
+	case mysql.MYSQL_TYPE_DOUBLE:
+		return "double"
+	case mysql.MYSQL_TYPE_NULL:
+		return "null"
+	case mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIMESTAMP2:
+		return "timestamp"
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE:
+		return "date"
+	case mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_TIME2:
+		return "time"
+	case mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2:
+		return "datetime"
+	case mysql.MYSQL_TYPE_YEAR:
+		return "year"
+	case mysql.MYSQL_TYPE_BIT:
+		return "bit"
+	case mysql.MYSQL_TYPE_JSON:
+		return "json"
+	case mysql.MYSQL_TYPE_GEOMETRY:
+		return "geometry"
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		if binary {
+			return "varbinary"
+		}
+		return "varchar"
+	case mysql.MYSQL_TYPE_STRING:
+		if binary {
+			return "binary"
+		}
+		return "char"
+	case mysql.MYSQL_TYPE_TINY_BLOB, mysql.MYSQL_TYPE_MEDIUM_BLOB, mysql.MYSQL_TYPE_LONG_BLOB, mysql.MYSQL_TYPE_BLOB:
+		// for blob columns, the meta is the number of bytes used to store the length
+		prefix := ""
+		switch tme.ColumnMeta[i] {
+		case 1:
+			prefix = "tiny"
+		case 3:
+			prefix = "medium"
+		case 4:
+			prefix = "long"
+		}
+		if binary {
+			return prefix + "blob"
+		}
+		return prefix + "text"
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package pump
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestTableInfoFromTableMap(t *testing.T) {
+	tme := &replication.TableMapEvent{
+		Schema:      []byte("my_db"),
+		Table:       []byte("my_table"),
+		ColumnCount: 4,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BLOB},
+		ColumnMeta:  []uint16{0, 255, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, 2},
+		ColumnName:  [][]byte{[]byte("id"), []byte("name"), []byte("status"), []byte("content")},
+
+		SignednessBitmap: []byte{0x80},
+		DefaultCharset:   []uint64{255, 1, binaryCollationId}, // the 2nd character column is binary
+		EnumStrValue:     [][][]byte{{[]byte("PAID"), []byte("REFUNDED")}},
+		PrimaryKey:       []uint64{0},
+	}
+
+	ti, ok := tableInfoFromTableMap(tme)
+	if !ok {
+		t.Fatal("should be resolved from binlog metadata")
+	}
+	if ti.Schema != "my_db" || ti.Table != "my_table" || len(ti.Columns) != 4 {
+		t.Fatalf("%+v", ti)
+	}
+
+	id := ti.Columns[0]
+	if id.ColumnName != "id" || id.DataType != "bigint" || !id.Unsigned || !id.PrimaryKey {
+		t.Fatalf("%+v", id)
+	}
+	name := ti.Columns[1]
+	if name.ColumnName != "name" || name.DataType != "varchar" || name.PrimaryKey {
+		t.Fatalf("%+v", name)
+	}
+	status := ti.Columns[2]
+	if status.DataType != "enum" || len(status.EnumValues) != 2 || status.EnumValues[0] != "PAID" {
+		t.Fatalf("%+v", status)
+	}
+	content := ti.Columns[3]
+	if content.DataType != "blob" {
+		t.Fatalf("%+v", content)
+	}
+
+	tme = &replication.TableMapEvent{
+		Schema:      []byte("my_db"),
+		Table:       []byte("my_table"),
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONGLONG},
+		ColumnMeta:  []uint16{0},
+	}
+	if _, ok := tableInfoFromTableMap(tme); ok {
+		t.Fatal("column names are missing, should fallback to information_schema")
+	}
+}
@@ -23,7 +23,7 @@ type Pipeline struct {
 	// event type regexp.
 	Type string
 
-	// event types: INS, UPD, DEL.
+	// event types: INS, UPD, DEL, DDL, SNAP.
 	Types []string `json:"-"`
 
 	// Whether pipeline is enabled.
@@ -33,6 +33,15 @@ type Pipeline struct {
 
 	// extra filtering conditions
 	Condition Condition `mapstructure:"condition"`
+
+	// publish one message per committed transaction that contains all the row changes, see TxStreamEvent.
+	Transactional bool `mapstructure:"transactional"`
+
+	// event format: v1 (default) - column values as strings, v2 - typed column values.
+	Format string `mapstructure:"format"`
+
+	// name of the source, if empty, events from all sources are subscribed.
+	Source string `mapstructure:"source"`
 }
 
 type GlobalFilter struct {
@@ -47,8 +56,9 @@ type EventMapping struct {
 }
 
 type EventPumpConfig struct {
-	Filter    GlobalFilter `mapstructure:"filter"`
-	Pipelines []Pipeline   `mapstructure:"pipeline"`
+	Filter    GlobalFilter   `mapstructure:"filter"`
+	Pipelines []Pipeline     `mapstructure:"pipeline"`
+	Sources   []SourceConfig `mapstructure:"source"`
 }
 
 func LoadConfig() EventPumpConfig {
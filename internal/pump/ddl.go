@@ -0,0 +1,403 @@
+package pump
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/charset"
+	tidbmysql "github.com/pingcap/tidb/pkg/parser/mysql"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+	"github.com/pingcap/tidb/pkg/parser/types"
+)
+
+var (
+	// parser is not thread-safe
+	ddlParserPool = sync.Pool{New: func() any { return parser.New() }}
+
+	// name of the table in DDL statement that can't be parsed, e.g., 'ALTER TABLE `db`.t1 ADD COLUMN p POINT'
+	ddlTableNameRegex = regexp.MustCompile("(?i)^(?:ALTER|CREATE|DROP|TRUNCATE|RENAME)\\s+(?:\\w+\\s+)*?TABLES?\\s+(?:IF\\s+(?:NOT\\s+)?EXISTS\\s+)?" +
+		"(`(?:[^`]|``)+`|[\\w$]+)(?:\\s*\\.\\s*(`(?:[^`]|``)+`|[\\w$]+))?")
+)
+
+// Table affected by DDL.
+type ddlTable struct {
+	Schema string
+	Table  string // empty if all tables in the schema are affected, e.g., DROP DATABASE
+}
+
+// Change to the table made by DDL.
+type ddlChange struct {
+	ddlTable
+
+	// Derive the columns after the DDL from the columns before the DDL, nil is returned if the table is dropped.
+	//
+	// Error is returned if the columns can't be derived from the statement, e.g., CREATE TABLE ... SELECT,
+	// or the statement can't be applied to the columns before the DDL.
+	apply func(lookup ddlColumnLookup) ([]ColumnInfo, error)
+}
+
+// Lookup the columns of the table before the DDL, nil if the columns are unknown or the table doesn't exist.
+type ddlColumnLookup func(t ddlTable) []ColumnInfo
+
+// Parse DDL statement(s) in QueryEvent, returns the changes to the tables affected, in the order of the statement.
+//
+// Table names without schema qualifier belong to the defaultSchema, i.e., the schema of the QueryEvent.
+func parseDDL(rail miso.Rail, defaultSchema string, sql string) ([]ddlChange, bool) {
+	if !maybeDDL(sql) {
+		return nil, false
+	}
+
+	p := ddlParserPool.Get().(*parser.Parser)
+	defer ddlParserPool.Put(p)
+
+	stmts, _, err := p.Parse(sql, "", "")
+	if err != nil {
+		t := looseDDLTable(defaultSchema, sql)
+		rail.Warnf("Failed to parse DDL statement, table definition of %v.%v is reset, statement: '%v', %v", t.Schema, t.Table, sql, err)
+		return []ddlChange{{ddlTable: t, apply: func(lookup ddlColumnLookup) ([]ColumnInfo, error) {
+			return nil, errors.New("DDL statement can't be parsed")
+		}}}, true
+	}
+
+	changes := []ddlChange{}
+	tableOf := func(tn *ast.TableName) ddlTable {
+		schema := tn.Schema.O
+		if schema == "" {
+			schema = defaultSchema
+		}
+		return ddlTable{Schema: schema, Table: tn.Name.O}
+	}
+	add := func(t ddlTable, apply func(lookup ddlColumnLookup) ([]ColumnInfo, error)) {
+		changes = append(changes, ddlChange{ddlTable: t, apply: apply})
+	}
+	dropped := func(lookup ddlColumnLookup) ([]ColumnInfo, error) { return nil, nil }
+	alter := func(from ddlTable, fn func(cols []ColumnInfo) ([]ColumnInfo, error)) func(lookup ddlColumnLookup) ([]ColumnInfo, error) {
+		return func(lookup ddlColumnLookup) ([]ColumnInfo, error) {
+			cols := lookup(from)
+			if cols == nil {
+				return nil, fmt.Errorf("columns of %v.%v before the DDL are unknown", from.Schema, from.Table)
+			}
+			return fn(slices.Clone(cols))
+		}
+	}
+	unchanged := func(cols []ColumnInfo) ([]ColumnInfo, error) { return cols, nil }
+
+	for _, stmt := range stmts {
+		switch st := stmt.(type) {
+		case *ast.AlterTableStmt:
+			t := tableOf(st.Table)
+			apply := alter(t, func(cols []ColumnInfo) ([]ColumnInfo, error) { return alterDDLColumns(cols, st.Specs) })
+			var renamed *ast.TableName
+			for _, spec := range st.Specs {
+				if spec.Tp == ast.AlterTableRenameTable {
+					renamed = spec.NewTable
+				}
+			}
+			if renamed != nil {
+				add(tableOf(renamed), apply) // derived before the old one is dropped
+				add(t, dropped)
+			} else {
+				add(t, apply)
+			}
+		case *ast.CreateTableStmt:
+			t := tableOf(st.Table)
+			switch {
+			case st.ReferTable != nil: // CREATE TABLE ... LIKE
+				add(t, alter(tableOf(st.ReferTable), unchanged))
+			case st.Select != nil:
+				add(t, func(lookup ddlColumnLookup) ([]ColumnInfo, error) {
+					return nil, errors.New("columns of CREATE TABLE ... SELECT can't be derived from the statement")
+				})
+			default:
+				add(t, func(lookup ddlColumnLookup) ([]ColumnInfo, error) {
+					if cols := lookup(t); st.IfNotExists && cols != nil {
+						return cols, nil // table already exists
+					}
+					return createDDLColumns(st)
+				})
+			}
+		case *ast.DropTableStmt:
+			for _, tn := range st.Tables {
+				add(tableOf(tn), dropped)
+			}
+		case *ast.TruncateTableStmt:
+			t := tableOf(st.Table)
+			add(t, alter(t, unchanged))
+		case *ast.RenameTableStmt:
+			for _, tt := range st.TableToTables {
+				from := tableOf(tt.OldTable)
+				add(tableOf(tt.NewTable), alter(from, unchanged))
+				add(from, dropped)
+			}
+		case *ast.CreateIndexStmt:
+			t := tableOf(st.Table)
+			add(t, alter(t, unchanged))
+		case *ast.DropIndexStmt:
+			t := tableOf(st.Table)
+			add(t, alter(t, func(cols []ColumnInfo) ([]ColumnInfo, error) {
+				if strings.EqualFold(st.IndexName, "PRIMARY") {
+					setDDLPrimaryKey(cols, nil)
+				}
+				return cols, nil
+			}))
+		case *ast.DropDatabaseStmt:
+			add(ddlTable{Schema: st.Name.O}, dropped)
+		}
+	}
+	return changes, len(changes) > 0
+}
+
+// Extract the table name from DDL statement that can't be parsed, all tables in the defaultSchema are affected if it's not found.
+func looseDDLTable(defaultSchema string, sql string) ddlTable {
+	m := ddlTableNameRegex.FindStringSubmatch(trimLeadingComments(sql))
+	if m == nil {
+		return ddlTable{Schema: defaultSchema}
+	}
+	unquote := func(v string) string {
+		if strings.HasPrefix(v, "`") {
+			return strings.ReplaceAll(v[1:len(v)-1], "``", "`")
+		}
+		return v
+	}
+	if m[2] == "" {
+		return ddlTable{Schema: defaultSchema, Table: unquote(m[1])}
+	}
+	return ddlTable{Schema: unquote(m[1]), Table: unquote(m[2])}
+}
+
+// Columns of CREATE TABLE statement.
+func createDDLColumns(st *ast.CreateTableStmt) ([]ColumnInfo, error) {
+	cols := make([]ColumnInfo, 0, len(st.Cols))
+	for _, def := range st.Cols {
+		cols = append(cols, newDDLColumn(def))
+	}
+	for _, c := range st.Constraints {
+		if c.Tp == ast.ConstraintPrimaryKey {
+			if err := setDDLPrimaryKey(cols, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return normalizeDDLColumns(cols), nil
+}
+
+// Apply the specs of ALTER TABLE statement to the columns.
+func alterDDLColumns(cols []ColumnInfo, specs []*ast.AlterTableSpec) ([]ColumnInfo, error) {
+	var err error
+	for _, spec := range specs {
+		switch spec.Tp {
+		case ast.AlterTableAddColumns:
+			for i, def := range spec.NewColumns {
+				col := newDDLColumn(def)
+				if ddlColumnIndex(cols, col.ColumnName) > -1 {
+					if spec.IfNotExists {
+						continue
+					}
+					return nil, fmt.Errorf("column '%v' already exists", col.ColumnName)
+				}
+				pos := spec.Position
+				if i > 0 {
+					pos = nil
+				}
+				if cols, err = insertDDLColumn(cols, col, pos); err != nil {
+					return nil, err
+				}
+			}
+		case ast.AlterTableDropColumn:
+			i := ddlColumnIndex(cols, spec.OldColumnName.Name.O)
+			if i < 0 {
+				if spec.IfExists {
+					continue
+				}
+				return nil, fmt.Errorf("column '%v' not found", spec.OldColumnName.Name.O)
+			}
+			cols = slices.Delete(cols, i, i+1)
+		case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+			if len(spec.NewColumns) < 1 {
+				continue
+			}
+			col := newDDLColumn(spec.NewColumns[0])
+			name := col.ColumnName
+			if spec.OldColumnName != nil {
+				name = spec.OldColumnName.Name.O
+			}
+			i := ddlColumnIndex(cols, name)
+			if i < 0 {
+				if spec.IfExists {
+					continue
+				}
+				return nil, fmt.Errorf("column '%v' not found", name)
+			}
+			if j := ddlColumnIndex(cols, col.ColumnName); j > -1 && j != i {
+				return nil, fmt.Errorf("column '%v' already exists", col.ColumnName)
+			}
+			col.PrimaryKey = col.PrimaryKey || cols[i].PrimaryKey // primary key is not changed unless specified
+			if spec.Position == nil || spec.Position.Tp == ast.ColumnPositionNone {
+				cols[i] = col
+				continue
+			}
+			cols = slices.Delete(cols, i, i+1)
+			if cols, err = insertDDLColumn(cols, col, spec.Position); err != nil {
+				return nil, err
+			}
+		case ast.AlterTableRenameColumn:
+			i := ddlColumnIndex(cols, spec.OldColumnName.Name.O)
+			if i < 0 {
+				return nil, fmt.Errorf("column '%v' not found", spec.OldColumnName.Name.O)
+			}
+			if j := ddlColumnIndex(cols, spec.NewColumnName.Name.O); j > -1 && j != i {
+				return nil, fmt.Errorf("column '%v' already exists", spec.NewColumnName.Name.O)
+			}
+			cols[i].ColumnName = spec.NewColumnName.Name.O
+		case ast.AlterTableAddConstraint:
+			if spec.Constraint != nil && spec.Constraint.Tp == ast.ConstraintPrimaryKey {
+				if err := setDDLPrimaryKey(cols, spec.Constraint); err != nil {
+					return nil, err
+				}
+			}
+		case ast.AlterTableDropPrimaryKey:
+			setDDLPrimaryKey(cols, nil)
+		case ast.AlterTableDropIndex:
+			if strings.EqualFold(spec.Name, "PRIMARY") {
+				setDDLPrimaryKey(cols, nil)
+			}
+		case ast.AlterTableOption:
+			for _, opt := range spec.Options {
+				// CONVERT TO CHARACTER SET binary changes the text columns to binary columns
+				if opt.Tp == ast.TableOptionCharset && opt.UintValue == ast.TableOptionCharsetWithConvertTo &&
+					strings.EqualFold(opt.StrValue, charset.CharsetBin) {
+					return nil, errors.New("columns converted to binary charset can't be derived from the statement")
+				}
+			}
+		}
+		// the other specs don't change the columns, e.g., ADD INDEX, RENAME TO, ALTER COLUMN ... SET DEFAULT
+	}
+	return normalizeDDLColumns(cols), nil
+}
+
+func newDDLColumn(def *ast.ColumnDef) ColumnInfo {
+	ft := def.Tp
+	col := ColumnInfo{
+		ColumnName: def.Name.Name.O,
+		DataType:   types.TypeToStr(ft.GetType(), ft.GetCharset()),
+		ColumnType: ft.InfoSchemaStr(),
+		Unsigned:   tidbmysql.HasUnsignedFlag(ft.GetFlag()),
+	}
+	switch ft.GetType() {
+	case tidbmysql.TypeEnum:
+		col.EnumValues = ft.GetElems()
+	case tidbmysql.TypeSet:
+		col.SetValues = ft.GetElems()
+	}
+	for _, opt := range def.Options {
+		if opt.Tp == ast.ColumnOptionPrimaryKey {
+			col.PrimaryKey = true
+		}
+	}
+	return col
+}
+
+func insertDDLColumn(cols []ColumnInfo, col ColumnInfo, pos *ast.ColumnPosition) ([]ColumnInfo, error) {
+	if pos == nil {
+		return append(cols, col), nil
+	}
+	switch pos.Tp {
+	case ast.ColumnPositionFirst:
+		return slices.Insert(cols, 0, col), nil
+	case ast.ColumnPositionAfter:
+		i := ddlColumnIndex(cols, pos.RelativeColumn.Name.O)
+		if i < 0 {
+			return nil, fmt.Errorf("column '%v' not found", pos.RelativeColumn.Name.O)
+		}
+		return slices.Insert(cols, i+1, col), nil
+	}
+	return append(cols, col), nil
+}
+
+// Replace the primary key with the columns in the constraint, primary key is dropped if constraint is nil.
+func setDDLPrimaryKey(cols []ColumnInfo, c *ast.Constraint) error {
+	for i := range cols {
+		cols[i].PrimaryKey = false
+	}
+	if c == nil {
+		return nil
+	}
+	for _, k := range c.Keys {
+		if k.Column == nil {
+			return errors.New("primary key on expression is not supported")
+		}
+		i := ddlColumnIndex(cols, k.Column.Name.O)
+		if i < 0 {
+			return fmt.Errorf("column '%v' not found", k.Column.Name.O)
+		}
+		cols[i].PrimaryKey = true
+	}
+	return nil
+}
+
+func normalizeDDLColumns(cols []ColumnInfo) []ColumnInfo {
+	for i := range cols {
+		cols[i].OrdinalPosition = i + 1
+		if cols[i].PrimaryKey {
+			cols[i].ColumnKey = "PRI"
+		} else if cols[i].ColumnKey == "PRI" {
+			cols[i].ColumnKey = ""
+		}
+	}
+	return cols
+}
+
+// Column names are case-insensitive.
+func ddlColumnIndex(cols []ColumnInfo, name string) int {
+	return slices.IndexFunc(cols, func(c ColumnInfo) bool { return strings.EqualFold(c.ColumnName, name) })
+}
+
+// Quick check to avoid parsing DML or transaction control statements, e.g., BEGIN, COMMIT.
+func maybeDDL(sql string) bool {
+	s := trimLeadingComments(sql)
+	i := strings.IndexAny(s, " \t\r\n")
+	if i < 0 {
+		return false
+	}
+	switch strings.ToUpper(s[:i]) {
+	case "ALTER", "CREATE", "DROP", "TRUNCATE", "RENAME":
+		return true
+	}
+	return false
+}
+
+// Trim leading whitespaces and comments, e.g., '/* gh-ost */ ALTER TABLE ...'.
+//
+// Content of executable comments is kept, e.g., 'ALTER TABLE ... */' is returned for '/*!40000 ALTER TABLE ... */'.
+func trimLeadingComments(sql string) string {
+	s := strings.TrimSpace(sql)
+	for {
+		switch {
+		case strings.HasPrefix(s, "/*!"), strings.HasPrefix(s, "/*M!"):
+			s = s[strings.IndexByte(s, '!')+1:]
+			s = strings.TrimLeft(s, "0123456789") // version
+		case strings.HasPrefix(s, "/*"):
+			i := strings.Index(s, "*/")
+			if i < 0 {
+				return ""
+			}
+			s = s[i+2:]
+		case strings.HasPrefix(s, "--"), strings.HasPrefix(s, "#"):
+			i := strings.IndexByte(s, '\n')
+			if i < 0 {
+				return ""
+			}
+			s = s[i+1:]
+		default:
+			return s
+		}
+		s = strings.TrimSpace(s)
+	}
+}
@@ -5,8 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"os"
-	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,8 +18,6 @@ import (
 	"github.com/curtisnewbie/miso/util/osutil"
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/replication"
-	"github.com/spf13/cast"
-	"gorm.io/gorm"
 )
 
 const (
@@ -32,54 +29,28 @@ const (
 	PropSyncPosFile      = "sync.pos.file"
 	PropSyncMaxReconnect = "sync.max-reconnect"
 	PropLogEvent         = "sync.log-event"
+	PropSyncFlavor       = "sync.flavor"
 
-	flavorMysql = "mysql"
+	flavorMysql   = mysql.MySQLFlavor
+	flavorMariaDB = mysql.MariaDBFlavor
 
 	TypeInsert = "INS"
 	TypeUpdate = "UPD"
 	TypeDelete = "DEL"
-)
+	TypeDDL    = "DDL"
 
-var (
-	currPos            mysql.Position = mysql.Position{Name: "", Pos: 0}
-	nextPos            mysql.Position = currPos
-	lastBinlogTime     time.Time
-	lastBinlogWarnTime time.Time
-	binlogPosHealthy   = true
-	posMu              sync.RWMutex
+	TypeSnapshot = "SNAP" // row read from the table in snapshot
 )
 
 const (
-	binlogWarnTimeThreshold time.Duration = time.Minute * 5
-	binlogWarnInterval      time.Duration = time.Minute * 1
-)
-
-var (
-	// posFile is flushed in every 1s (at most)
-	updatePosFileTicker *miso.TickRunner = miso.NewTickRuner(time.Millisecond*1000, FlushPos)
-
-	posFile      *os.File = nil
-	tableInfoMap          = make(map[string]TableInfo)
-	conn         *gorm.DB = nil
-
-	_globalInclude *regexp.Regexp = nil
-	_globalExclude *regexp.Regexp = nil
-)
-
-var (
-	handlers = map[string]EventHandler{}
-	hdmu     sync.RWMutex
+	binlogWarnInterval time.Duration = time.Minute * 1
 )
 
 var (
-	doAttachPosFunc func(rail miso.Rail) error           = attachLocalPosFile
-	doDetachPosFunc func(rail miso.Rail)                 = detachLocalPosFile
-	doFlushPosFunc  func(byt []byte) error               = flushLocalPosFile
-	doReadPosFunc   func(rail miso.Rail) ([]byte, error) = readLocalPosFile
-)
-
-var (
-	resyncErrCount int32 = 0
+	handlers         = map[string]EventHandler{}
+	txCommitHandlers = map[string]TxCommitHandler{}
+	txAbortHandlers  = map[string]TxAbortHandler{}
+	hdmu             sync.RWMutex
 )
 
 func init() {
@@ -91,25 +62,73 @@ func init() {
 	miso.SetDefProp(PropSyncPassword, "")
 	miso.SetDefProp(PropSyncPosFile, "binlog_pos")
 	miso.SetDefProp(PropSyncMaxReconnect, 120)
+	miso.SetDefProp(PropSyncFlavor, flavorMysql)
+}
+
+// Binlog position persisted in pos file (or zookeeper).
+//
+// The json format is compatible with mysql.Position, GTID is only present in GTID mode.
+type BinlogPos struct {
+	mysql.Position
+	GTID string `json:",omitempty"` // executed GTID set
 }
 
 type Record struct {
 	Before []interface{} `json:"before"`
 	After  []interface{} `json:"after"`
+
+	// index of columns that are not present in the row image, e.g., binlog_row_image=MINIMAL or NOBLOB.
+	BeforeAbsent []int `json:"beforeAbsent,omitempty"`
+	AfterAbsent  []int `json:"afterAbsent,omitempty"`
+
+	// JSON patch operations of the partially updated JSON columns (PARTIAL_UPDATE_ROWS_EVENT) that can't be applied to the before image, the columns are in AfterAbsent.
+	AfterJsonPatch map[int][]JsonPatchOp `json:"afterJsonPatch,omitempty"`
+}
+
+// Whether the j-th column is present in the before image.
+func (r Record) HasBefore(j int) bool {
+	return j < len(r.Before) && !slices.Contains(r.BeforeAbsent, j)
+}
+
+// Whether the j-th column is present in the after image.
+func (r Record) HasAfter(j int) bool {
+	return j < len(r.After) && !slices.Contains(r.AfterAbsent, j)
 }
 
 type DataChangeEvent struct {
+	Source    string         `json:"source"`    // name of the source
 	Timestamp uint32         `json:"timestamp"` // epoch time second
 	Schema    string         `json:"schema"`
 	Table     string         `json:"table"`
-	Type      string         `json:"type"` // INS-INSERT, UPD-UPDATE, DEL-DELETE
+	Type      string         `json:"type"` // INS-INSERT, UPD-UPDATE, DEL-DELETE, DDL-DDL, SNAP-SNAPSHOT
 	Records   []Record       `json:"records"`
-	Columns   []RecordColumn `json:"columns"`
+	Columns   []RecordColumn `json:"columns"` // for DDL, it's the columns after the DDL
+
+	// transaction id, it's the GTID if available, otherwise the binlog position of the beginning of the transaction.
+	TxId string `json:"txId"`
+
+	// sequence of the first record within the transaction, records in the event take TxSeq, TxSeq+1, ...
+	TxSeq int `json:"txSeq"`
+
+	// binlog coordinates of the event
+	BinlogFile string `json:"binlogFile"`
+	BinlogPos  uint32 `json:"binlogPos"` // start position of the binlog event
+	ServerId   uint32 `json:"serverId"`  // server id of the instance that wrote the binlog event
+	GTID       string `json:"gtid,omitempty"`
+
+	// index of the event within the compressed transaction payload (starting from 1), 0 if the transaction is not compressed.
+	PayloadIndex int `json:"payloadIndex,omitempty"`
+
+	// only for DDL
+	Statement  string         `json:"statement,omitempty"`  // the DDL statement
+	OldColumns []RecordColumn `json:"oldColumns,omitempty"` // columns before the DDL
 }
 
 type RecordColumn struct {
-	Name     string `json:"name"`
-	DataType string `json:"dataType"`
+	Name       string `json:"name"`
+	DataType   string `json:"dataType"`
+	Unsigned   bool   `json:"unsigned,omitempty"`
+	PrimaryKey bool   `json:"primaryKey,omitempty"`
 }
 
 func (d DataChangeEvent) String() string {
@@ -118,8 +137,12 @@ func (d DataChangeEvent) String() string {
 		rs = append(rs, d.PrintRecord(r))
 	}
 	joinedRecords := strings.Join(rs, ", ")
-	return fmt.Sprintf("DataChangeEvent{ Timestamp: %v, Schema: %v, Table: %v, Type: %v, Records: [ %v ] }",
-		d.Timestamp, d.Schema, d.Table, d.Type, joinedRecords)
+	if d.Type == TypeDDL {
+		return fmt.Sprintf("DataChangeEvent{ Source: %v, Timestamp: %v, Schema: %v, Table: %v, Type: %v, Statement: %v, OldColumns: %v, Columns: %v }",
+			d.Source, d.Timestamp, d.Schema, d.Table, d.Type, d.Statement, d.OldColumns, d.Columns)
+	}
+	return fmt.Sprintf("DataChangeEvent{ Source: %v, Timestamp: %v, Schema: %v, Table: %v, Type: %v, Records: [ %v ] }",
+		d.Source, d.Timestamp, d.Schema, d.Table, d.Type, joinedRecords)
 }
 
 func (d DataChangeEvent) PrintRecord(r Record) string {
@@ -164,6 +187,11 @@ func OnEventReceived(handler EventHandler) string {
 }
 
 func callEventHandlers(c miso.Rail, dce DataChangeEvent) error {
+	return callEventHandlersExcept(c, dce, hash.Set[string]{})
+}
+
+// Call the EventHandlers except the ones registered with the handlerIds in skipped.
+func callEventHandlersExcept(c miso.Rail, dce DataChangeEvent, skipped hash.Set[string]) error {
 	hdmu.RLock()
 	defer hdmu.RUnlock()
 
@@ -171,7 +199,10 @@ func callEventHandlers(c miso.Rail, dce DataChangeEvent) error {
 		StreamDispatched: hash.NewSet[string](),
 	}
 
-	for _, handle := range handlers {
+	for id, handle := range handlers {
+		if skipped.Has(id) {
+			continue
+		}
 		if e := handle(c, dce, ctx); e != nil {
 			return e
 		}
@@ -179,28 +210,115 @@ func callEventHandlers(c miso.Rail, dce DataChangeEvent) error {
 	return nil
 }
 
+// Call the EventHandler registered with the handlerId only.
+func callEventHandler(c miso.Rail, handlerId string, dce DataChangeEvent) error {
+	hdmu.RLock()
+	defer hdmu.RUnlock()
+
+	if handle, ok := handlers[handlerId]; ok {
+		return handle(c, dce, &EventHandleContext{StreamDispatched: hash.NewSet[string]()})
+	}
+	return nil
+}
+
 type EventHandleContext struct {
 	StreamDispatched hash.Set[string]
 }
 
+type TxCommitHandler func(c miso.Rail, tx TxInfo) error
+
+// Register handler that is called when the transaction is committed.
+//
+// handlerId is the id returned by OnEventReceived, the handler is removed along with the EventHandler.
+func OnTxCommitted(handlerId string, handler TxCommitHandler) {
+	hdmu.Lock()
+	defer hdmu.Unlock()
+	txCommitHandlers[handlerId] = handler
+}
+
+func callTxCommitHandlers(c miso.Rail, tx TxInfo) error {
+	return callTxCommitHandlersExcept(c, tx, hash.Set[string]{})
+}
+
+// Call the TxCommitHandlers except the ones registered with the handlerIds in skipped.
+func callTxCommitHandlersExcept(c miso.Rail, tx TxInfo, skipped hash.Set[string]) error {
+	hdmu.RLock()
+	defer hdmu.RUnlock()
+
+	for id, handle := range txCommitHandlers {
+		if skipped.Has(id) {
+			continue
+		}
+		if e := handle(c, tx); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Call the TxCommitHandler registered with the handlerId only.
+func callTxCommitHandler(c miso.Rail, handlerId string, tx TxInfo) error {
+	hdmu.RLock()
+	defer hdmu.RUnlock()
+
+	if handle, ok := txCommitHandlers[handlerId]; ok {
+		return handle(c, tx)
+	}
+	return nil
+}
+
+type TxAbortHandler func(c miso.Rail, source string)
+
+// Register handler that is called when the incomplete transaction of the source is discarded, e.g., the stream is restarted.
+//
+// The transaction is replayed from the beginning after the stream is restarted.
+//
+// handlerId is the id returned by OnEventReceived, the handler is removed along with the EventHandler.
+func OnTxAborted(handlerId string, handler TxAbortHandler) {
+	hdmu.Lock()
+	defer hdmu.Unlock()
+	txAbortHandlers[handlerId] = handler
+}
+
+func callTxAbortHandlers(c miso.Rail, source string) {
+	hdmu.RLock()
+	defer hdmu.RUnlock()
+
+	for _, handle := range txAbortHandlers {
+		handle(c, source)
+	}
+}
+
 func RemoveEventHandler(handlerId string) {
 	hdmu.Lock()
 	defer hdmu.Unlock()
 	delete(handlers, handlerId)
+	delete(txCommitHandlers, handlerId)
+	delete(txAbortHandlers, handlerId)
 }
 
-func newDataChangeEvent(table TableInfo, re *replication.RowsEvent, timestamp uint32) DataChangeEvent {
-	cn := []RecordColumn{}
-	for _, ci := range table.Columns {
-		cn = append(cn, RecordColumn{Name: ci.ColumnName, DataType: ci.DataType})
-	}
+func (s *Source) newDataChangeEvent(ev *replication.BinlogEvent, table TableInfo) DataChangeEvent {
 	return DataChangeEvent{
-		Timestamp: timestamp,
-		Schema:    table.Schema,
-		Table:     table.Table,
-		Records:   []Record{},
-		Columns:   cn,
+		Source:     s.Name,
+		Timestamp:  ev.Header.Timestamp,
+		Schema:     table.Schema,
+		Table:      table.Table,
+		Records:    []Record{},
+		Columns:    newRecordColumns(table.Columns),
+		BinlogFile: s.currentBinlogFile(),
+		BinlogPos:  ev.Header.LogPos - ev.Header.EventSize,
+		ServerId:   ev.Header.ServerID,
+
+		PayloadIndex: s.payloadIdx,
+	}
+}
+
+func newRecordColumns(columns []ColumnInfo) []RecordColumn {
+	cn := []RecordColumn{}
+	for _, ci := range columns {
+		cn = append(cn, RecordColumn{Name: ci.ColumnName, DataType: ci.DataType, Unsigned: ci.Unsigned, PrimaryKey: ci.PrimaryKey})
 	}
+	return cn
 }
 
 type TableInfo struct {
@@ -212,61 +330,387 @@ type TableInfo struct {
 type ColumnInfo struct {
 	ColumnName      string `gorm:"column:COLUMN_NAME"`
 	DataType        string `gorm:"column:DATA_TYPE"`
+	ColumnType      string `gorm:"column:COLUMN_TYPE"`
+	ColumnKey       string `gorm:"column:COLUMN_KEY"`
 	OrdinalPosition int    `gorm:"column:ORDINAL_POSITION"`
+
+	// parsed from COLUMN_TYPE and COLUMN_KEY, or from binlog metadata.
+	Unsigned   bool     `gorm:"-"`
+	PrimaryKey bool     `gorm:"-"`
+	EnumValues []string `gorm:"-"`
+	SetValues  []string `gorm:"-"`
 }
 
-func FetchTableInfo(c miso.Rail, schema string, table string) (TableInfo, error) {
+func (s *Source) FetchTableInfo(c miso.Rail, schema string, table string) (TableInfo, error) {
+	if s.conn == nil {
+		return TableInfo{}, fmt.Errorf("table definition of %v.%v is not available, not connected to master instance of source '%v'", schema, table, s.Name)
+	}
 	var columns []ColumnInfo
-	e := conn.
+	e := s.conn.
 		Table("information_schema.columns").
-		Select("column_name COLUMN_NAME, ordinal_position ORDINAL_POSITION, data_type DATA_TYPE").
+		Select("column_name COLUMN_NAME, ordinal_position ORDINAL_POSITION, data_type DATA_TYPE, column_type COLUMN_TYPE, column_key COLUMN_KEY").
 		Where("table_schema = ? AND table_name = ?", schema, table).
 		Order("ordinal_position asc").
 		Scan(&columns).Error
+	for i := range columns {
+		c := &columns[i]
+		c.Unsigned = strings.Contains(strings.ToLower(c.ColumnType), "unsigned")
+		c.PrimaryKey = strings.EqualFold(c.ColumnKey, "PRI")
+		switch strings.ToLower(c.DataType) {
+		case "enum":
+			c.EnumValues = parseColumnTypeLabels(c.ColumnType)
+		case "set":
+			c.SetValues = parseColumnTypeLabels(c.ColumnType)
+		}
+	}
 	return TableInfo{Table: table, Schema: schema, Columns: columns}, e
 }
 
-func ResetTableInfoCache(c miso.Rail, schema string, table string) {
+func (s *Source) ResetTableInfoCache(c miso.Rail, schema string, table string) {
 	k := schema + "." + table
-	delete(tableInfoMap, k)
+	delete(s.tableInfoMap, k)
 	c.Infof("Reset TableInfo cache, %v.%v", schema, table)
 }
 
-func CachedTableInfo(c miso.Rail, schema string, table string) (TableInfo, error) {
+// Reset TableInfo cache of all tables in the schema, returns the names of tables reset.
+func (s *Source) ResetSchemaTableInfoCache(c miso.Rail, schema string) []string {
+	tables := []string{}
+	prefix := schema + "."
+	for k := range s.tableInfoMap {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.tableInfoMap, k)
+			tables = append(tables, strings.TrimPrefix(k, prefix))
+		}
+	}
+	for _, t := range s.schemaHist.Tables(schema) {
+		if !slices.Contains(tables, t) {
+			tables = append(tables, t)
+		}
+	}
+	c.Infof("Reset TableInfo cache of schema %v, tables: %v", schema, tables)
+	return tables
+}
+
+func (s *Source) CachedTableInfo(c miso.Rail, schema string, table string) (TableInfo, error) {
 	k := schema + "." + table
-	ti, ok := tableInfoMap[k]
+	ti, ok := s.tableInfoMap[k]
 	if ok {
 		return ti, nil
 	}
 
-	fti, e := FetchTableInfo(c, schema, table)
+	fti, e := s.FetchTableInfo(c, schema, table)
 	if e != nil {
 		return TableInfo{}, e
 	}
 
-	tableInfoMap[k] = fti
+	s.tableInfoMap[k] = fti
 	return fti, nil
 }
 
-func PumpEvents(rootRail miso.Rail, syncer *replication.BinlogSyncer, streamer *replication.BinlogStreamer) error {
+// Resolve TableInfo of the RowsEvent.
+//
+// Binlog metadata in TableMapEvent is preferred, it's always consistent with the rows in the binlog event,
+// information_schema is only used as a fallback.
+// When information_schema is used, the table definition is versioned in schema history,
+// such that the rows are decoded using the table definition at the time the rows were written.
+//
+// Error is returned if the table definition doesn't match the binlog event, the rows can't be decoded correctly.
+func (s *Source) resolveTableInfo(rail miso.Rail, ev *replication.BinlogEvent, re *replication.RowsEvent) (TableInfo, error) {
+	schema := string(re.Table.Schema)
+	table := string(re.Table.Table)
+	k := schema + "." + table
+
+	if ti, ok := tableInfoFromTableMap(re.Table); ok {
+		s.tableInfoMap[k] = ti // the last known columns if the table is changed by DDL
+		return ti, nil
+	}
+
+	pos := mysql.Position{Name: s.currentBinlogFile(), Pos: ev.Header.LogPos}
+	if v, ok := s.schemaHist.Lookup(k, pos); ok {
+		if len(v.Columns) != int(re.Table.ColumnCount) {
+			return TableInfo{}, fmt.Errorf("schema history of %v since %v doesn't match the binlog event at %v, expected %d columns, but found %d columns,"+
+				" consider enabling binlog_row_metadata=FULL", k, v.Pos, pos, re.Table.ColumnCount, len(v.Columns))
+		}
+		return TableInfo{Schema: schema, Table: table, Columns: v.Columns}, nil
+	}
+
+	ti, e := s.CachedTableInfo(rail, schema, table)
+	if e != nil {
+		return TableInfo{}, e
+	}
+	if len(ti.Columns) != int(re.Table.ColumnCount) {
+		// the table may have been changed after the rows were written, it's not recorded in schema history
+		s.ResetTableInfoCache(rail, schema, table)
+		return TableInfo{}, fmt.Errorf("table definition of %v doesn't match the binlog event at %v, expected %d columns, but information_schema has %d columns,"+
+			" consider enabling binlog_row_metadata=FULL", k, pos, re.Table.ColumnCount, len(ti.Columns))
+	}
+	v := s.schemaHist.Record(k, pos, ti.Columns)
+	rail.Infof("Recorded schema version of %v since %v", k, v.Pos)
+	return ti, nil
+}
+
+func (s *Source) handleRowsEvent(rail miso.Rail, ev *replication.BinlogEvent, re *replication.RowsEvent, typ string) error {
+	schema := string(re.Table.Schema)
+	if !s.includeSchema(schema) {
+		return nil
+	}
+
+	tableInfo, e := s.resolveTableInfo(rail, ev, re)
+	if e != nil {
+		return e
+	}
+
+	if ev.Header.EventType == replication.PARTIAL_UPDATE_ROWS_EVENT {
+		if e := decodeJsonDiffVectors(ev, re); e != nil {
+			return e
+		}
+	}
+
+	for _, row := range re.Rows {
+		convertUnsigned(tableInfo.Columns, row)
+		resolveEnumSetLabels(tableInfo.Columns, row)
+	}
+
+	dce := s.newDataChangeEvent(ev, tableInfo)
+	dce.Type = typ
+
+	skipped := func(i int) []int {
+		if i < len(re.SkippedColumns) && len(re.SkippedColumns[i]) > 0 {
+			return re.SkippedColumns[i]
+		}
+		return nil
+	}
+
+	switch typ {
+	case TypeUpdate:
+		// N is before, N + 1 is after
+		rec := Record{}
+		for i, row := range re.Rows {
+			before := (i+1)%2 != 0
+			if before {
+				rec.Before = row
+				rec.BeforeAbsent = skipped(i)
+			} else {
+				rec.After = row
+				rec.AfterAbsent = skipped(i)
+				rebuildPartialJson(&rec)
+				dce.Records = append(dce.Records, rec)
+				rec = Record{}
+			}
+		}
+	case TypeInsert:
+		for i, row := range re.Rows {
+			dce.Records = append(dce.Records, Record{After: row, AfterAbsent: skipped(i)})
+		}
+	case TypeDelete:
+		for i, row := range re.Rows {
+			dce.Records = append(dce.Records, Record{Before: row, BeforeAbsent: skipped(i)})
+		}
+	}
+
+	s.assignTx(ev, &dce)
+	return callEventHandlersExcept(rail, dce, s.snapshottedHandlers(rail))
+}
+
+// Convert values of unsigned integer columns in place.
+//
+// go-mysql always decodes integers as signed, values that overflow the signed range become negative.
+func convertUnsigned(columns []ColumnInfo, row []any) {
+	for i, v := range row {
+		if i >= len(columns) || !columns[i].Unsigned {
+			continue
+		}
+		switch vt := v.(type) {
+		case int8:
+			row[i] = uint8(vt)
+		case int16:
+			row[i] = uint16(vt)
+		case int32:
+			if strings.EqualFold(columns[i].DataType, "mediumint") {
+				row[i] = uint32(vt) & 0xFFFFFF
+			} else {
+				row[i] = uint32(vt)
+			}
+		case int64:
+			row[i] = uint64(vt)
+		}
+	}
+}
+
+// Resolve ENUM index and SET bitmask to their labels in place.
+//
+// Values are left untouched if the labels are unknown.
+func resolveEnumSetLabels(columns []ColumnInfo, row []any) {
+	for i, v := range row {
+		if i >= len(columns) {
+			break
+		}
+		c := columns[i]
+		if len(c.EnumValues) < 1 && len(c.SetValues) < 1 {
+			continue
+		}
+		n, ok := v.(int64)
+		if !ok {
+			continue
+		}
+		if len(c.EnumValues) > 0 {
+			// index starts at 1, 0 is the empty string used for invalid values
+			if n == 0 {
+				row[i] = ""
+			} else if n > 0 && int(n) <= len(c.EnumValues) {
+				row[i] = c.EnumValues[n-1]
+			}
+			continue
+		}
+		labels := make([]string, 0, len(c.SetValues))
+		for j, l := range c.SetValues {
+			if n&(1<<j) != 0 {
+				labels = append(labels, l)
+			}
+		}
+		row[i] = strings.Join(labels, ",")
+	}
+}
+
+// Parse labels in COLUMN_TYPE, e.g., "enum('a','b')" or "set('a','b')".
+func parseColumnTypeLabels(columnType string) []string {
+	start := strings.IndexByte(columnType, '(')
+	end := strings.LastIndexByte(columnType, ')')
+	if start < 0 || end <= start {
+		return nil
+	}
+	body := columnType[start+1 : end]
+
+	labels := []string{}
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		if !quoted {
+			if ch == '\'' {
+				quoted = true
+				b.Reset()
+			}
+			continue
+		}
+		if ch == '\'' {
+			if i+1 < len(body) && body[i+1] == '\'' { // escaped quote
+				b.WriteByte(ch)
+				i++
+				continue
+			}
+			quoted = false
+			labels = append(labels, b.String())
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return labels
+}
+
+// Handle DDL in QueryEvent.
+//
+// Table definition after the DDL is derived by applying the DDL to the last known definition, instead of fetching it
+// from information_schema, which is only consistent with the binlog when event-pump is not lagging behind.
+// If it can't be derived, the columns are omitted in the published DDL event, and the definition is fetched when
+// the rows are received.
+func (s *Source) handleQueryEvent(rail miso.Rail, ev *replication.BinlogEvent, qe *replication.QueryEvent) error {
+
+	// parse the tables affected
+	changes, ok := parseDDL(rail, string(qe.Schema), string(qe.Query))
+	if !ok {
+		return nil
+	}
+
+	pos := mysql.Position{Name: s.currentBinlogFile(), Pos: ev.Header.LogPos}
+
+	// columns changed by the previous statements in the same event, e.g., RENAME TABLE a TO tmp, b TO a, tmp TO b
+	changed := map[ddlTable][]ColumnInfo{}
+	lookup := func(t ddlTable) []ColumnInfo {
+		if cols, ok := changed[t]; ok {
+			return cols
+		}
+		return s.lastKnownColumns(t.Schema+"."+t.Table, pos)
+	}
+
+	for _, c := range changes {
+		if !s.includeSchema(c.Schema) {
+			continue
+		}
+
+		affected := []string{c.Table}
+		if c.Table == "" {
+			affected = s.ResetSchemaTableInfoCache(rail, c.Schema)
+		}
+
+		for _, table := range affected {
+			t := ddlTable{Schema: c.Schema, Table: table}
+			k := t.Schema + "." + t.Table
+			oldColumns := lookup(t)
+			newColumns, err := c.apply(lookup)
+			if err != nil {
+				rail.Warnf("Unable to derive table definition of %v after DDL, it's fetched when the rows are received, statement: '%v', %v",
+					k, string(qe.Query), err)
+				newColumns = nil
+			}
+			changed[t] = newColumns
+
+			s.ResetTableInfoCache(rail, t.Schema, t.Table)
+			s.schemaHist.OnDDL(k, pos, newColumns)
+
+			dce := s.newDataChangeEvent(ev, TableInfo{Schema: t.Schema, Table: t.Table, Columns: newColumns})
+			dce.Type = TypeDDL
+			dce.Statement = string(qe.Query)
+			dce.OldColumns = newRecordColumns(oldColumns)
+			s.assignTx(ev, &dce)
+			if e := callEventHandlersExcept(rail, dce, s.snapshottedHandlers(rail)); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Columns of the table before the position, either from schema history or the cache.
+func (s *Source) lastKnownColumns(k string, pos mysql.Position) []ColumnInfo {
+	if v, ok := s.schemaHist.Lookup(k, pos); ok {
+		return v.Columns
+	}
+	if ti, ok := s.tableInfoMap[k]; ok {
+		return ti.Columns
+	}
+	return nil
+}
+
+func (s *Source) PumpEvents(rootRail miso.Rail) error {
 	logEvent := miso.GetPropBool(PropLogEvent)
+	s.resetLag()
 
 	for {
 		select {
 		case <-rootRail.Context().Done():
-			rootRail.Info("Context cancelled, exiting PumpEvents()")
+			rootRail.Info("Context cancelled, exiting s.PumpEvents()")
 			return nil
 		default:
 			rail := miso.EmptyRail()
+
+			// snapshot for the pipelines added, it's only taken between transactions
+			if !s.isInTx() && s.snapshotRequested.CompareAndSwap(true, false) {
+				if err := s.snapshotPendingPipelines(rootRail); err != nil {
+					s.snapshotRequested.Store(true) // retried when the stream is restarted
+					return fmt.Errorf("failed to take snapshot of source '%v', %w", s.Name, err)
+				}
+			}
+
 			var ev *replication.BinlogEvent
 			var err error
 			{
 				ctx, timeoutCleanup := context.WithTimeout(rail.Context(), 60*time.Second)
-				ev, err = streamer.GetEvent(ctx)
+				ev, err = s.streamer.GetEvent(ctx)
 				timeoutCleanup()
 			}
 			if err != nil {
-				retry := atomic.AddInt32(&resyncErrCount, 1)
+				retry := atomic.AddInt32(&s.resyncErrCount, 1)
 				if retry > 9 {
 					rail.Errorf("GetEvent returned error, abort, already retried %v times, %v", retry, err)
 					return err
@@ -276,405 +720,442 @@ func PumpEvents(rootRail miso.Rail, syncer *replication.BinlogSyncer, streamer *
 			}
 
 			t := NewBinlogEventTimer()
-			atomic.StoreInt32(&resyncErrCount, 0) // reset the err count
+			atomic.StoreInt32(&s.resyncErrCount, 0) // reset the err count
+			s.observeLag(ev)
 			if logEvent {
 				evtLogBuf := strings.Builder{}
 				ev.Dump(&evtLogBuf)
 				rail.Info(evtLogBuf.String())
 			}
 
-			/*
-				We are not using Table.ColumnNameString() to resolve the actual column names, the column names are actually
-				fetched from the master instance using simple queries.
-
-				e.g.,
+			if e := s.handleEvent(rail, ev); e != nil {
+				return e
+			}
 
-					ev.Event.(*replication.RowsEvent).Table.ColumnNameString()
+			rail.Infof("binlog event processed, took: %v", t.ObserveDuration())
 
-				It's not very useful, it requires `binlog_row_metadata=FULL` and MySQL >= 8.0
+			if miso.IsShuttingDown() {
+				rail.Info("Server shutting down")
+				return nil
+			}
+		}
+	}
+}
 
-				https://dev.mysql.com/doc/refman/8.0/en/replication-options-binary-log.html#sysvar_binlog_row_metadata
+// Handle binlog event, the events are either streamed from master, or parsed from local binlog files.
+func (s *Source) handleEvent(rail miso.Rail, ev *replication.BinlogEvent) error {
+	/*
+		Column names are resolved using the optional metadata in TableMapEvent, e.g.,
 
-				TODO: The code is quite redundant, refactor it
+			ev.Event.(*replication.RowsEvent).Table.ColumnNameString()
 
-				About events:
+		It requires `binlog_row_metadata=FULL` and MySQL >= 8.0.1, if the metadata is missing,
+		the column names are fetched from the master instance using simple queries on information_schema.
 
-					https://dev.mysql.com/doc/dev/mysql-server/latest/classbinary__log_1_1Table__map__event.html
-			*/
+		https://dev.mysql.com/doc/refman/8.0/en/replication-options-binary-log.html#sysvar_binlog_row_metadata
 
-			switch ev.Header.EventType {
+		About events:
 
-			case replication.QUERY_EVENT:
+			https://dev.mysql.com/doc/dev/mysql-server/latest/classbinary__log_1_1Table__map__event.html
+	*/
 
-				// the table may be changed, reset the cache
-				if qe, ok := ev.Event.(*replication.QueryEvent); ok {
+	// track the beginning of transaction
+	s.beginTx(rail, ev)
 
-					// parse the table
-					if table, ok := parseAlterTable(string(qe.Query)); ok {
-						ResetTableInfoCache(rail, string(qe.Schema), table)
-					}
-				}
+	switch ev.Header.EventType {
 
-			case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
-
-				if re, ok := ev.Event.(*replication.RowsEvent); ok {
-
-					schema := string(re.Table.Schema)
-					if !includeSchema(schema) {
-						goto event_handle_end
-					}
-
-					// TODO: this is a problem if the delay is way too high
-					tableInfo, e := CachedTableInfo(rail, schema, string(re.Table.Table))
-					if e != nil {
-						return e
-					}
-
-					dce := newDataChangeEvent(tableInfo, re, ev.Header.Timestamp)
-					dce.Type = TypeUpdate
-					rec := Record{}
-
-					// N is before, N + 1 is after
-					for i, row := range re.Rows {
-						before := (i+1)%2 != 0
-						if before {
-							rec.Before = row
-						} else {
-							rec.After = row
-							dce.Records = append(dce.Records, rec)
-							rec = Record{}
-						}
-					}
-
-					if e := callEventHandlers(rail, dce); e != nil {
-						return e
-					}
-				}
+	case replication.QUERY_EVENT, replication.MARIADB_QUERY_COMPRESSED_EVENT:
 
-			case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		// the table may be changed, reset the cache
+		if qe, ok := ev.Event.(*replication.QueryEvent); ok {
+			if e := s.handleQueryEvent(rail, ev, qe); e != nil {
+				return e
+			}
+		}
 
-				if re, ok := ev.Event.(*replication.RowsEvent); ok {
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2,
+		replication.MARIADB_UPDATE_ROWS_COMPRESSED_EVENT_V1, replication.PARTIAL_UPDATE_ROWS_EVENT:
 
-					schema := string(re.Table.Schema)
-					if !includeSchema(schema) {
-						goto event_handle_end
-					}
+		if re, ok := ev.Event.(*replication.RowsEvent); ok {
+			if e := s.handleRowsEvent(rail, ev, re, TypeUpdate); e != nil {
+				return e
+			}
+		}
 
-					tableInfo, e := CachedTableInfo(rail, schema, string(re.Table.Table))
-					if e != nil {
-						return e
-					}
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2,
+		replication.MARIADB_WRITE_ROWS_COMPRESSED_EVENT_V1:
 
-					dce := newDataChangeEvent(tableInfo, re, ev.Header.Timestamp)
-					dce.Type = TypeInsert
+		if re, ok := ev.Event.(*replication.RowsEvent); ok {
+			if e := s.handleRowsEvent(rail, ev, re, TypeInsert); e != nil {
+				return e
+			}
+		}
 
-					for _, row := range re.Rows {
-						dce.Records = append(dce.Records, Record{After: row})
-					}
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2,
+		replication.MARIADB_DELETE_ROWS_COMPRESSED_EVENT_V1:
 
-					if e := callEventHandlers(rail, dce); e != nil {
-						return e
-					}
-				}
-			case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
-				if re, ok := ev.Event.(*replication.RowsEvent); ok {
-					schema := string(re.Table.Schema)
-					if !includeSchema(schema) {
-						goto event_handle_end
-					}
-
-					tableInfo, e := CachedTableInfo(rail, schema, string(re.Table.Table))
-					if e != nil {
-						return e
-					}
-					dce := newDataChangeEvent(tableInfo, re, ev.Header.Timestamp)
-					dce.Type = TypeDelete
-
-					for _, row := range re.Rows {
-						dce.Records = append(dce.Records, Record{Before: row})
-					}
-
-					if e := callEventHandlers(rail, dce); e != nil {
-						return e
-					}
-				}
+		if re, ok := ev.Event.(*replication.RowsEvent); ok {
+			if e := s.handleRowsEvent(rail, ev, re, TypeDelete); e != nil {
+				return e
 			}
+		}
 
-			// end of event handling, we are mainly handling log pos here
-		event_handle_end:
-
-			var logPos uint32
-			var logFileName string
+	case replication.TRANSACTION_PAYLOAD_EVENT:
 
-			// we don't always update pos on all events, even though some of them have position
-			// if we update whenever we can, we may end up being stuck somewhere the next time we
-			// startup the app again
-			switch t := ev.Event.(type) {
+		if pe, ok := ev.Event.(*replication.TransactionPayloadEvent); ok {
+			if e := s.handlePayloadEvent(rail, ev, pe); e != nil {
+				return e
+			}
+		}
+	}
 
-			// for RotateEvent, LogPosition can be 0, have to use Position instead
-			case *replication.RotateEvent:
-				logPos = uint32(t.Position)
-				logFileName = string(t.NextLogName)
-			case *replication.TableMapEvent:
-				// do nothing, see: https://github.com/go-mysql-org/go-mysql/issues/48
+	// end of transaction
+	txEnded, e := s.commitTx(rail, ev)
+	if e != nil {
+		return e
+	}
 
-			/*
-				- QueryEvent if some DDL is executed
-				- the go-mysql-elasticsearch also update it's pos on XIDEvent
+	// end of event handling, we are mainly handling log pos here
+
+	var logPos uint32
+	var logFileName string
+
+	// we don't always update pos on all events, even though some of them have position
+	// if we update whenever we can, we may end up being stuck somewhere the next time we
+	// startup the app again
+	//
+	// pos is not updated within transaction, we always resume from the beginning of a transaction,
+	// otherwise, the TableMapEvent may be missing and the transaction is incomplete.
+	switch t := ev.Event.(type) {
+
+	// for RotateEvent, LogPosition can be 0, have to use Position instead
+	case *replication.RotateEvent:
+		logPos = uint32(t.Position)
+		logFileName = string(t.NextLogName)
+	case *replication.TableMapEvent:
+		// do nothing, see: https://github.com/go-mysql-org/go-mysql/issues/48
+
+	/*
+		- QueryEvent if some DDL is executed
+		- the go-mysql-elasticsearch also update it's pos on XIDEvent
+
+		according to the doc: "An XID event is generated for a commit of a transaction that modifies one or more tables of an XA-capable storage engine"
+		https://dev.mysql.com/doc/dev/mysql-server/latest/classXid__log__event.html
+
+		it does seems like it's the 2PC thing for between the server and innodb engine in binlog
+	*/
+	// case *replication.QueryEvent, *replication.XIDEvent:
+	// 	logPos = ev.Header.LogPos
+
+	default:
+		if ev.Header.LogPos > 0 && !s.isInTx() {
+			logPos = ev.Header.LogPos
+		}
+	}
 
-				according to the doc: "An XID event is generated for a commit of a transaction that modifies one or more tables of an XA-capable storage engine"
-				https://dev.mysql.com/doc/dev/mysql-server/latest/classXid__log__event.html
+	// update position
+	s.updatePos(rail, mysql.Position{Name: logFileName, Pos: logPos})
 
-				it does seems like it's the 2PC thing for between the server and innodb engine in binlog
-			*/
-			// case *replication.QueryEvent, *replication.XIDEvent:
-			// 	logPos = ev.Header.LogPos
+	// update executed GTID set, only in GTID mode
+	s.trackGTID(rail, ev, txEnded)
 
-			default:
-				if ev.Header.LogPos > 0 {
-					logPos = ev.Header.LogPos
-				}
-			}
+	return nil
+}
 
-			// update position
-			updatePos(rail, mysql.Position{Name: logFileName, Pos: logPos})
+func (s *Source) updatePos(c miso.Rail, p mysql.Position) {
+	s.posMu.Lock()
+	defer s.posMu.Unlock()
 
-			rail.Infof("binlog event processed, took: %v", t.ObserveDuration())
+	if (p.Name == "" || p.Name == s.nextPos.Name) && (p.Pos < 1 || p.Pos == s.nextPos.Pos) {
+		return
+	}
 
-			if miso.IsShuttingDown() {
-				rail.Info("Server shutting down")
-				return nil
-			}
-		}
+	if p.Name != "" {
+		s.nextPos.Name = p.Name
 	}
+	s.nextPos.Pos = p.Pos
+	c.Infof("Next pos: %+v", s.nextPos)
 }
 
-func checkBinlogHealth() bool {
-	posMu.RLock()
-	defer posMu.RUnlock()
-	return binlogPosHealthy
+func (s *Source) currentBinlogFile() string {
+	s.posMu.RLock()
+	defer s.posMu.RUnlock()
+	return s.nextPos.Name
 }
 
-func updatePos(c miso.Rail, p mysql.Position) {
-	posMu.Lock()
-	defer posMu.Unlock()
+func (s *Source) updateGTID(c miso.Rail, gtid string) {
+	s.posMu.Lock()
+	defer s.posMu.Unlock()
 
-	lastBinlogTime = time.Now()
-	binlogPosHealthy = true
-	if (p.Name == "" || p.Name == nextPos.Name) && (p.Pos < 1 || p.Pos == nextPos.Pos) {
+	if s.nextPos.GTID == gtid {
 		return
 	}
-
-	if p.Name != "" {
-		nextPos.Name = p.Name
-	}
-	nextPos.Pos = p.Pos
-	c.Infof("Next pos: %+v", nextPos)
+	s.nextPos.GTID = gtid
+	c.Debugf("Next GTID set: %v", gtid)
 }
 
-func readLocalPosFile(c miso.Rail) ([]byte, error) {
-	return io.ReadAll(posFile)
+func (s *Source) readLocalPosFile(c miso.Rail) ([]byte, error) {
+	// the file is read again when the stream is restarted, the offset is already moved by previous read
+	if _, err := s.posFile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read posFile, %w", err)
+	}
+	return io.ReadAll(s.posFile)
 }
 
-func NewStreamer(c miso.Rail, syncer *replication.BinlogSyncer) (*replication.BinlogStreamer, error) {
-	pos, err := ReadPos(c)
+func (s *Source) NewStreamer(c miso.Rail) (*replication.BinlogStreamer, error) {
+	pos, initial, err := s.readPos(c)
 	if err != nil {
 		return nil, err
 	}
-	return syncer.StartSync(pos)
+
+	if s.isGTIDMode() {
+		if pos.GTID == "" && !initial { // the initial GTID set can be empty, e.g., starting from the earliest
+			return nil, fmt.Errorf("GTID mode is enabled, but the executed GTID set is missing in binlog position: %+v,"+
+				" please write the executed GTID set to the position file", pos)
+		}
+		gset, err := mysql.ParseGTIDSet(s.flavor(), pos.GTID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse GTID set: '%v', %w", pos.GTID, err)
+		}
+		s.resetGTIDSet(gset)
+		s.streamer, err = s.syncer.StartSyncGTID(gset.Clone())
+		return s.streamer, err
+	}
+	s.streamer, err = s.syncer.StartSync(pos.Position)
+	return s.streamer, err
 }
 
-func PrepareSync(rail miso.Rail) (*replication.BinlogSyncer, error) {
+func (s *Source) PrepareSync(rail miso.Rail) (*replication.BinlogSyncer, error) {
 	cfg := replication.BinlogSyncerConfig{
-		ServerID:             uint32(miso.GetPropInt(PropSyncServerId)),
-		Flavor:               flavorMysql,
-		Host:                 miso.GetPropStr(PropSyncHost),
-		Port:                 uint16(miso.GetPropInt(PropSyncPort)),
-		User:                 miso.GetPropStr(PropSyncUser),
-		Password:             miso.GetPropStr(PropSyncPassword),
-		MaxReconnectAttempts: miso.GetPropInt(PropSyncMaxReconnect),
+		ServerID:             uint32(s.conf.ServerId),
+		Flavor:               s.flavor(),
+		Host:                 s.conf.Host,
+		Port:                 uint16(s.conf.Port),
+		User:                 s.conf.User,
+		Password:             s.conf.Password,
+		MaxReconnectAttempts: s.conf.MaxReconnect,
 		HeartbeatPeriod:      time.Second * 5,
 		Logger:               rail,
 		ReadTimeout:          time.Second * 30,
 	}
 
 	p := ms.MySQLConnParam{
-		User:     miso.GetPropStr(PropSyncUser),
-		Password: miso.GetPropStr(PropSyncPassword),
-		Host:     miso.GetPropStr(PropSyncHost),
-		Port:     miso.GetPropInt(PropSyncPort),
+		User:     s.conf.User,
+		Password: s.conf.Password,
+		Host:     s.conf.Host,
+		Port:     s.conf.Port,
+	}
+
+	tlsConfig, err := buildTLSConfig(s.conf.TLS, s.conf.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS config of source '%v', %w", s.Name, err)
+	}
+	if tlsConfig != nil {
+		cfg.TLSConfig = tlsConfig
+		p.ConnParam, err = registerDriverTLSConfig(s.Name, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		rail.Infof("TLS enabled for connections to %v:%v, server-name: '%v', skip-verify: %v",
+			p.Host, p.Port, tlsConfig.ServerName, tlsConfig.InsecureSkipVerify)
 	}
 	client, err := ms.NewMySQLConn(rail, p)
 	if err != nil {
 		return nil, err
 	}
-	conn = client
+	s.conn = client
 	if !miso.IsProdMode() {
-		conn = conn.Debug()
-	}
-
-	return replication.NewBinlogSyncer(cfg), nil
-}
-
-func includeSchema(schema string) bool {
-	if _globalExclude != nil && _globalExclude.MatchString(schema) { // exclude specified and matched
-		return false
+		s.conn = s.conn.Debug()
 	}
-	if _globalInclude != nil && !_globalInclude.MatchString(schema) { // include specified, but doesn't match
-		return false
+	if err := s.detectServerVersion(rail); err != nil {
+		return nil, err
 	}
-	return true
-}
-
-func SetGlobalInclude(r *regexp.Regexp) {
-	_globalInclude = r
-}
 
-func SetGlobalExclude(r *regexp.Regexp) {
-	_globalExclude = r
+	s.syncerConf = cfg
+	s.syncer = replication.NewBinlogSyncer(cfg)
+	return s.syncer, nil
 }
 
-var alterTableRegex = regexp.MustCompile(`(?i)^\s*alter table ([\w_\d]+) .*$`)
-
-func parseAlterTable(sql string) (string, bool) {
-	matched := alterTableRegex.FindStringSubmatch(sql)
-	if matched == nil {
-		return "", false
+func (s *Source) AttachPos(rail miso.Rail) error {
+	err := s.doAttachPosFunc(rail)
+	if err == nil {
+		err = s.LoadSchemaHistory(rail)
+	}
+	if err == nil {
+		err = s.LoadSnapshotState(rail)
 	}
-
-	return matched[1], true
-}
-
-func AttachPos(rail miso.Rail) error {
-	err := doAttachPosFunc(rail)
 	if err == nil {
 		// start ticker to periodically flush posFile
-		updatePosFileTicker.Start()
+		s.updatePosFileTicker.Start()
 	}
 	return err
 }
 
-func attachLocalPosFile(rail miso.Rail) error {
-	pf := miso.GetPropStr(PropSyncPosFile)
+func (s *Source) attachLocalPosFile(rail miso.Rail) error {
+	pf := s.conf.PosFile
 	rail.Infof("Attaching to pos file: %v", pf)
 	f, err := osutil.OpenRWFile(pf)
 	if err != nil {
 		return fmt.Errorf("failed to attach to pos file: %v, %w", pf, err)
 	}
-	posFile = f
+	s.posFile = f
 	rail.Infof("Attached to pos file: %v", pf)
 	return nil
 }
 
-func DetachPos(rail miso.Rail) {
-	FlushPos()
-	doDetachPosFunc(rail)
+func (s *Source) DetachPos(rail miso.Rail) {
+	s.updatePosFileTicker.Stop()
+	s.FlushPos()
+	s.doDetachPosFunc(rail)
 }
 
-func detachLocalPosFile(rail miso.Rail) {
-	if posFile == nil {
+func (s *Source) detachLocalPosFile(rail miso.Rail) {
+	if s.posFile == nil {
 		return
 	}
-	posFile.Close()
-	posFile = nil
+	s.posFile.Close()
+	s.posFile = nil
 	rail.Info("Local posFile detached")
 }
 
-func FlushPos() {
-	posMu.Lock()
-	defer posMu.Unlock()
+func (s *Source) FlushPos() {
+	// schema history must be flushed before the position
+	if err := s.FlushSchemaHistory(); err != nil {
+		miso.Errorf("failed to flush schema history of source '%v', %v", s.Name, err)
+		return
+	}
 
-	now := time.Now()
-	if currPos.Name == nextPos.Name && currPos.Pos == nextPos.Pos {
-		if lastBinlogTime.IsZero() {
-			lastBinlogTime = now
-		} else if now.Sub(lastBinlogTime) > binlogWarnTimeThreshold {
-			// log warning message
-			if now.After(lastBinlogWarnTime.Add(binlogWarnInterval)) {
-				lastBinlogWarnTime = now
-				miso.Warnf("Binlog pos hasn't changed for a while, last time binlog event received was %v, currPos: %+v", lastBinlogTime.Format(util.StdDateTimeMilliFormat), currPos)
-			}
+	s.posMu.Lock()
+	defer s.posMu.Unlock()
 
-			// mark unhealthy
-			binlogPosHealthy = false
-		}
+	if s.currPos == s.nextPos {
 		return
 	}
-	s, e := json.Marshal(nextPos)
+	byt, e := json.Marshal(s.nextPos)
 	if e != nil {
-		miso.Errorf("failed to update posFile, unable to marshal pos %+v, %v", nextPos, e)
+		miso.Errorf("failed to update posFile, unable to marshal pos %+v, %v", s.nextPos, e)
 		return
 	}
-	err := doFlushPosFunc(s)
+	err := s.doFlushPosFunc(byt)
 	if err == nil {
-		miso.Infof("pos moved from %+v to %+v", currPos, nextPos)
-		currPos = nextPos
+		miso.Infof("pos of source '%v' moved from %+v to %+v", s.Name, s.currPos, s.nextPos)
+		s.currPos = s.nextPos
+		s.releaseSnapshotHolds()
 	}
 }
 
-func flushLocalPosFile(s []byte) error {
-	posFile.Truncate(0)
-	if _, err := posFile.WriteAt(s, 0); err != nil {
-		return fmt.Errorf("failed to write posFile, content: %s, %v", s, err)
+func (s *Source) flushLocalPosFile(byt []byte) error {
+	s.posFile.Truncate(0)
+	if _, err := s.posFile.WriteAt(byt, 0); err != nil {
+		return fmt.Errorf("failed to write posFile, content: %s, %v", byt, err)
 	}
-	if err := posFile.Sync(); err != nil {
-		return fmt.Errorf("failed to fsync posFile, content: %s, %v", s, err)
+	if err := s.posFile.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync posFile, content: %s, %v", byt, err)
 	}
 	return nil
 }
 
-func SetupPosFileStorage(isHaMode bool) {
+func (s *Source) SetupPosFileStorage(isHaMode bool) {
 	if isHaMode {
-		doAttachPosFunc = attachZkPosFile
-		doDetachPosFunc = detachZkPosFile
-		doFlushPosFunc = flushZkPosFile
-		doReadPosFunc = readZkPosFile
+		s.doAttachPosFunc = s.attachZkPosFile
+		s.doDetachPosFunc = s.detachZkPosFile
+		s.doFlushPosFunc = s.flushZkPosFile
+		s.doReadPosFunc = s.readZkPosFile
+		s.doFlushSchemaHistFunc = s.flushZkSchemaHistFile
+		s.doReadSchemaHistFunc = s.readZkSchemaHistFile
+		s.doFlushSnapStateFunc = s.flushZkSnapStateFile
+		s.doReadSnapStateFunc = s.readZkSnapStateFile
+	} else {
+		s.doAttachPosFunc = s.attachLocalPosFile
+		s.doDetachPosFunc = s.detachLocalPosFile
+		s.doFlushPosFunc = s.flushLocalPosFile
+		s.doReadPosFunc = s.readLocalPosFile
+		s.doFlushSchemaHistFunc = s.flushLocalSchemaHistFile
+		s.doReadSchemaHistFunc = s.readLocalSchemaHistFile
+		s.doFlushSnapStateFunc = s.flushLocalSnapStateFile
+		s.doReadSnapStateFunc = s.readLocalSnapStateFile
 	}
 }
 
-func ReadPos(rail miso.Rail) (mysql.Position, error) {
-	byt, err := doReadPosFunc(rail)
+func (s *Source) ReadPos(rail miso.Rail) (BinlogPos, error) {
+	pos, _, err := s.readPos(rail)
+	return pos, err
+}
+
+// Read the stored binlog position, initial position is determined by `start-from` if it's missing.
+func (s *Source) readPos(rail miso.Rail) (pos BinlogPos, initial bool, err error) {
+	byt, err := s.doReadPosFunc(rail)
 	if err != nil {
-		return mysql.Position{}, err
+		return BinlogPos{}, false, err
 	}
-	if len(byt) < 1 { // for the first time, fetch from master
-		ms, err := FetchMasterStatus(rail)
+	if len(byt) < 1 { // for the first time, determined by start-from, or the position recorded by snapshot
+		if s.conf.Snapshot.Enabled {
+			pos, err = s.takeSnapshot(rail)
+		} else {
+			pos, err = s.initialPos(rail, s.startFrom)
+		}
 		if err != nil {
-			rail.Warnf("Failed to fetch master status, %v", err)
-			return mysql.Position{}, err // the earliest binlog
+			return BinlogPos{}, true, err
 		}
-		rail.Infof("Binlog position missing, fetched from master node, %#v", ms)
-		return mysql.Position{Name: ms.File, Pos: cast.ToUint32(ms.Position)}, nil // the latest binlog
+		rail.Infof("Binlog position of source '%v' missing, start from %v: %+v", s.Name, s.startFrom, pos)
+
+		s.posMu.Lock()
+		defer s.posMu.Unlock()
+		s.nextPos = pos // make sure the initial position is flushed
+		return pos, true, nil
 	}
-	s := util.UnsafeByt2Str(byt)
-	if s == "" {
-		return mysql.Position{}, nil
+	str := util.UnsafeByt2Str(byt)
+	if str == "" {
+		return BinlogPos{}, false, nil
 	}
 
-	var pos mysql.Position
-	e := json.Unmarshal([]byte(s), &pos)
-	if e != nil {
-		return mysql.Position{}, e
+	pos, err = parseBinlogPos(str)
+	if err != nil {
+		return BinlogPos{}, false, err
 	}
 
-	posMu.Lock()
-	defer posMu.Unlock()
+	purged, err := s.isPosPurged(rail, pos)
+	if err != nil {
+		return BinlogPos{}, false, err
+	}
+	if purged {
+		if pos, err = s.handlePurgedPos(rail, pos); err != nil {
+			return BinlogPos{}, false, err
+		}
+		s.posMu.Lock()
+		defer s.posMu.Unlock()
+		s.nextPos = pos // make sure the new position is flushed
+		return pos, true, nil
+	}
 
-	currPos = pos
-	nextPos = currPos
-	rail.Infof("Last position: %v - %v", pos.Name, pos.Pos)
+	s.posMu.Lock()
+	defer s.posMu.Unlock()
 
-	return pos, nil
+	s.currPos = pos
+	s.nextPos = s.currPos
+	if pos.GTID != "" {
+		rail.Infof("Last position of source '%v': %v - %v, GTID: %v", s.Name, pos.Name, pos.Pos, pos.GTID)
+	} else {
+		rail.Infof("Last position of source '%v': %v - %v", s.Name, pos.Name, pos.Pos)
+	}
+
+	return pos, false, nil
+}
+
+func parseBinlogPos(s string) (BinlogPos, error) {
+	var pos BinlogPos
+	e := json.Unmarshal([]byte(s), &pos)
+	return pos, e
 }
 
-func attachZkPosFile(rail miso.Rail) error {
-	buf, err := readZkPosFile(rail)
+func (s *Source) attachZkPosFile(rail miso.Rail) error {
+	buf, err := s.readZkPosFile(rail)
 	if err == nil && buf == nil {
 		// node doesn't exist
-		pf := miso.GetPropStr(PropSyncPosFile)
+		pf := s.conf.PosFile
 		if pf != "" {
 			if f, err := osutil.ReadFileAll(pf); err == nil {
-				if er := ZkWritePos(f); er != nil {
+				if er := s.flushZkPosFile(f); er != nil {
 					rail.Warnf("Unable to find pos node on Zookeeper. Attempted to fallback to local pos file but failed, %v", er)
 				}
 			}
@@ -684,24 +1165,38 @@ func attachZkPosFile(rail miso.Rail) error {
 	return nil
 }
 
-func detachZkPosFile(rail miso.Rail) {
+func (s *Source) detachZkPosFile(rail miso.Rail) {
 	// do nothing
 }
 
-func flushZkPosFile(byt []byte) error {
-	return ZkWritePos(byt)
+func (s *Source) flushZkPosFile(byt []byte) error {
+	return ZkWrite(s.zkPath(ZkPathPos), byt)
 }
 
-func readZkPosFile(rail miso.Rail) ([]byte, error) {
-	return ZkReadPos()
+func (s *Source) readZkPosFile(rail miso.Rail) ([]byte, error) {
+	return ZkRead(s.zkPath(ZkPathPos))
 }
 
 type MasterStatus struct {
-	File     string
-	Position string
+	File            string
+	Position        string
+	ExecutedGtidSet string `gorm:"column:Executed_Gtid_Set"`
 }
 
-func FetchMasterStatus(rail miso.Rail) (MasterStatus, error) {
+func (s *Source) FetchMasterStatus(rail miso.Rail) (MasterStatus, error) {
 	var ms MasterStatus
-	return ms, conn.Raw(`SHOW MASTER STATUS`).Scan(&ms).Error
+	stmt := masterStatusStmt(s.flavor(), s.serverVersion)
+	if err := s.conn.Raw(stmt).Scan(&ms).Error; err != nil {
+		return ms, fmt.Errorf("failed to fetch master status using '%v', %w", stmt, err)
+	}
+
+	// MariaDB doesn't include executed GTID set in master status
+	if s.flavor() == flavorMariaDB {
+		var gtid string
+		if err := s.conn.Raw(`SELECT @@GLOBAL.gtid_binlog_pos`).Scan(&gtid).Error; err != nil {
+			return ms, fmt.Errorf("failed to fetch gtid_binlog_pos, %w", err)
+		}
+		ms.ExecutedGtidSet = gtid
+	}
+	return ms, nil
 }
@@ -1,46 +1,387 @@
 package pump
 
 import (
-	"regexp"
+	"reflect"
+	"slices"
 	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
 )
 
 func TestIncludeSchema(t *testing.T) {
-	_globalInclude = regexp.MustCompile("^test$")
-	if includeSchema("test_send") {
+	s, err := NewSource(SourceConfig{Name: "test", Flavor: flavorMysql, Filter: GlobalFilter{Include: "^test$", Exclude: "^test_exclude$"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.includeSchema("test_send") {
+		t.Fatal()
+	}
+	if !s.includeSchema("test") {
 		t.Fatal()
 	}
 }
 
-func TestParseAlterTable(t *testing.T) {
-	tab, ok := parseAlterTable("    alter table my_table if not exists;")
-	if !ok {
-		t.FailNow()
+func TestParseDDLTables(t *testing.T) {
+	cases := []struct {
+		sql    string
+		tables []ddlTable
+	}{
+		{sql: "    alter table my_table add column name varchar(10);", tables: []ddlTable{{"db", "my_table"}}},
+		{sql: "ALTER TABLE other_db.my_table DROP COLUMN name", tables: []ddlTable{{"other_db", "my_table"}}},
+		{sql: "alter table `my-db`.`my table` add index idx_name (name)", tables: []ddlTable{{"my-db", "my table"}}},
+		{sql: "alter table t1 rename to t2", tables: []ddlTable{{"db", "t2"}, {"db", "t1"}}},
+		{sql: "RENAME TABLE a TO b, other_db.c TO other_db.d", tables: []ddlTable{{"db", "b"}, {"db", "a"}, {"other_db", "d"}, {"other_db", "c"}}},
+		{sql: "DROP TABLE IF EXISTS t1, other_db.t2", tables: []ddlTable{{"db", "t1"}, {"other_db", "t2"}}},
+		{sql: "truncate table `t1`", tables: []ddlTable{{"db", "t1"}}},
+		{sql: "create index idx_name on t1 (name)", tables: []ddlTable{{"db", "t1"}}},
+		{sql: "drop database logbot", tables: []ddlTable{{"logbot", ""}}},
+		{sql: `CREATE TABLE IF NOT EXISTS logbot.error_log (
+		id BIGINT(20) NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+		node VARCHAR(25) NOT NULL COMMENT 'node name',
+		err_msg TEXT COMMENT 'error msg',
+		rtime timestamp default current_timestamp COMMENT 'report time',
+		PRIMARY KEY (id),
+		INDEX idx_rtime (rtime)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Application Error Log';`, tables: []ddlTable{{"logbot", "error_log"}}},
+		{sql: "/* gh-ost */ ALTER TABLE t1 ADD COLUMN name varchar(10)", tables: []ddlTable{{"db", "t1"}}},
+		{sql: "-- migration\n# v2\nalter table t1 drop column name", tables: []ddlTable{{"db", "t1"}}},
+		{sql: "/*!40000 ALTER TABLE `t1` DISABLE KEYS */", tables: []ddlTable{{"db", "t1"}}},
+		{sql: "/* unterminated comment ALTER TABLE t1 DROP COLUMN name"},
+		{sql: "alter table t1 add column", tables: []ddlTable{{"db", "t1"}}}, // syntax error
+		{sql: "ALTER TABLE `other db`.`t``1` ADD COLUMN p POINT", tables: []ddlTable{{"other db", "t`1"}}},
+		{sql: "/* gh-ost */ create temporary table if not exists t1 (p point)", tables: []ddlTable{{"db", "t1"}}},
+		{sql: "create index idx_p on t1 (p) using unknown", tables: []ddlTable{{"db", ""}}}, // table name not found
+		{sql: "CREATE DATABASE IF NOT EXISTS logbot;"},
+		{sql: "BEGIN"},
+		{sql: "insert into t1 (name) values ('alter table t2')"},
 	}
-	if tab != "my_table" {
-		t.FailNow()
+
+	for _, c := range cases {
+		changes, ok := parseDDL(miso.EmptyRail(), "db", c.sql)
+		var tables []ddlTable
+		for _, c := range changes {
+			tables = append(tables, c.ddlTable)
+		}
+		if ok != (len(c.tables) > 0) {
+			t.Fatalf("sql: %v, expected: %v, actual: %v", c.sql, c.tables, tables)
+		}
+		if !slices.Equal(tables, c.tables) {
+			t.Fatalf("sql: %v, expected: %v, actual: %v", c.sql, c.tables, tables)
+		}
 	}
+}
 
-	_, ok = parseAlterTable(`CREATE DATABASE IF NOT EXISTS logbot;`)
-	if ok {
-		t.FailNow()
+func TestParseDDLColumns(t *testing.T) {
+	type col struct {
+		name     string
+		dataType string
+		pk       bool
 	}
+	tables := map[ddlTable][]ColumnInfo{}
+	lookup := func(t ddlTable) []ColumnInfo { return tables[t] }
 
-	_, ok = parseAlterTable(`CREATE TABLE IF NOT EXISTS logbot.error_log (
-		'id' BIGINT(20) NOT NULL AUTO_INCREMENT COMMENT 'primary key',
-		'node' VARCHAR(25) NOT NULL COMMENT 'node name',
-		'app' VARCHAR(25) NOT NULL COMMENT 'app name',
-		'caller' varchar(50) NOT NULL COMMENT 'caller name',
-		'trace_id' varchar(25) NOT NULL DEFAULT '' COMMENT 'trace id',
-		'span_id' varchar(25) NOT NULL DEFAULT '' COMMENT 'trace id',
-		'err_msg' TEXT COMMENT 'error msg',
-		'rtime' timestamp default current_timestamp COMMENT 'report time',
-		'ctime' timestamp default current_timestamp COMMENT 'create time',
-		PRIMARY KEY ('id'),
-		INDEX idx_rtime (rtime)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Application Error Log';`)
+	cases := []struct {
+		sql     string
+		table   ddlTable
+		columns []col // nil if the table is dropped
+		err     bool
+	}{
+		{sql: "create table t1 (id bigint unsigned primary key auto_increment, name varchar(10), data blob, status enum('a','b'))", table: ddlTable{"db", "t1"},
+			columns: []col{{"id", "bigint", true}, {"name", "varchar", false}, {"data", "blob", false}, {"status", "enum", false}}},
+		{sql: "create table if not exists t1 (id int)", table: ddlTable{"db", "t1"},
+			columns: []col{{"id", "bigint", true}, {"name", "varchar", false}, {"data", "blob", false}, {"status", "enum", false}}},
+		{sql: "alter table t1 add column age int after id, drop column data", table: ddlTable{"db", "t1"},
+			columns: []col{{"id", "bigint", true}, {"age", "int", false}, {"name", "varchar", false}, {"status", "enum", false}}},
+		{sql: "alter table t1 modify column name text first, change column age user_age bigint", table: ddlTable{"db", "t1"},
+			columns: []col{{"name", "text", false}, {"id", "bigint", true}, {"user_age", "bigint", false}, {"status", "enum", false}}},
+		{sql: "alter table t1 rename column user_age to age, drop primary key, add primary key (id, name)", table: ddlTable{"db", "t1"},
+			columns: []col{{"name", "text", true}, {"id", "bigint", true}, {"age", "bigint", false}, {"status", "enum", false}}},
+		{sql: "drop index `PRIMARY` on t1", table: ddlTable{"db", "t1"},
+			columns: []col{{"name", "text", false}, {"id", "bigint", false}, {"age", "bigint", false}, {"status", "enum", false}}},
+		{sql: "alter table t1 add column age int", table: ddlTable{"db", "t1"}, err: true},
+		{sql: "alter table t1 drop column missing", table: ddlTable{"db", "t1"}, err: true},
+		{sql: "alter table t1 add column if not exists age int, drop column if exists missing, add index idx_age (age)", table: ddlTable{"db", "t1"},
+			columns: []col{{"name", "text", false}, {"id", "bigint", false}, {"age", "bigint", false}, {"status", "enum", false}}},
+		{sql: "rename table t1 to t2", table: ddlTable{"db", "t2"},
+			columns: []col{{"name", "text", false}, {"id", "bigint", false}, {"age", "bigint", false}, {"status", "enum", false}}},
+		{sql: "create table other_db.t3 like t2", table: ddlTable{"other_db", "t3"},
+			columns: []col{{"name", "text", false}, {"id", "bigint", false}, {"age", "bigint", false}, {"status", "enum", false}}},
+		{sql: "drop table t2", table: ddlTable{"db", "t2"}},
+		{sql: "alter table t2 add column name varchar(10)", table: ddlTable{"db", "t2"}, err: true}, // unknown
+		{sql: "create table t4 as select * from other_db.t3", table: ddlTable{"db", "t4"}, err: true},
+		{sql: "alter table other_db.t3 convert to character set binary", table: ddlTable{"other_db", "t3"}, err: true},
+	}
+
+	for _, c := range cases {
+		changes, ok := parseDDL(miso.EmptyRail(), "db", c.sql)
+		if !ok {
+			t.Fatalf("sql: %v, not parsed", c.sql)
+		}
+		for _, ch := range changes {
+			cols, err := ch.apply(lookup)
+			if ch.ddlTable != c.table {
+				if err == nil {
+					tables[ch.ddlTable] = cols
+				}
+				continue
+			}
+			if (err != nil) != c.err {
+				t.Fatalf("sql: %v, err: %v", c.sql, err)
+			}
+			if err != nil {
+				continue
+			}
+			tables[ch.ddlTable] = cols
+			if (cols == nil) != (c.columns == nil) || len(cols) != len(c.columns) {
+				t.Fatalf("sql: %v, expected: %v, actual: %+v", c.sql, c.columns, cols)
+			}
+			for i, v := range c.columns {
+				if cols[i].ColumnName != v.name || cols[i].DataType != v.dataType || cols[i].PrimaryKey != v.pk || cols[i].OrdinalPosition != i+1 {
+					t.Fatalf("sql: %v, expected: %v, actual: %+v", c.sql, c.columns, cols)
+				}
+			}
+		}
+	}
+
+	// columns are derived from the previous version
+	id := tables[ddlTable{"other_db", "t3"}][1]
+	if id.ColumnType != "bigint(20) unsigned" || !id.Unsigned {
+		t.Fatalf("%+v", id)
+	}
+	status := tables[ddlTable{"other_db", "t3"}][3]
+	if !slices.Equal(status.EnumValues, []string{"a", "b"}) || status.ColumnType != "enum('a','b')" {
+		t.Fatalf("%+v", status)
+	}
+}
+
+func TestHandleUnparsableDDL(t *testing.T) {
+	s := newTestSource(t, flavorMysql)
+	s.nextPos = BinlogPos{Position: mysql.Position{Name: "binlog.000001", Pos: 4}}
+	rail := miso.EmptyRail()
+	v1 := []ColumnInfo{{ColumnName: "id"}, {ColumnName: "name"}}
+	s.tableInfoMap["db.t1"] = TableInfo{Schema: "db", Table: "t1", Columns: v1}
+	s.tableInfoMap["db.t2"] = TableInfo{Schema: "db", Table: "t2", Columns: v1}
+	s.schemaHist.Record("db.t1", mysql.Position{Name: "binlog.000001", Pos: 100}, v1)
+	s.schemaHist.Record("db.t3", mysql.Position{Name: "binlog.000001", Pos: 100}, v1)
+
+	var received []DataChangeEvent
+	id := OnEventReceived(func(c miso.Rail, dce DataChangeEvent, ctx *EventHandleContext) error {
+		received = append(received, dce)
+		return nil
+	})
+	defer RemoveEventHandler(id)
+
+	query := func(q string, logPos uint32) {
+		ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.QUERY_EVENT, LogPos: logPos},
+			Event: &replication.QueryEvent{Schema: []byte("db"), Query: []byte(q)}}
+		if err := s.handleEvent(rail, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pos := mysql.Position{Name: "binlog.000001", Pos: 300}
+
+	// the table is reset, the definition is fetched again when the rows are received
+	query("alter table t1 add column p point", 200)
+	if _, ok := s.tableInfoMap["db.t1"]; ok {
+		t.Fatal("cache should be reset")
+	}
+	if _, ok := s.schemaHist.Lookup("db.t1", pos); ok {
+		t.Fatal("schema history should be reset")
+	}
+	if len(received) != 1 || received[0].Table != "t1" || len(received[0].OldColumns) != 2 || len(received[0].Columns) != 0 {
+		t.Fatalf("%v", received)
+	}
+
+	// table name not found, all tables of the schema are reset
+	query("create index idx_p on t2 (p) using unknown", 250)
+	if len(s.tableInfoMap) != 0 {
+		t.Fatalf("cache should be reset, %v", s.tableInfoMap)
+	}
+	if _, ok := s.schemaHist.Lookup("db.t3", pos); ok {
+		t.Fatal("schema history should be reset")
+	}
+}
+
+func TestResolveTableInfo(t *testing.T) {
+	s := newTestSource(t, flavorMysql)
+	s.nextPos = BinlogPos{Position: mysql.Position{Name: "binlog.000001", Pos: 4}}
+	rail := miso.EmptyRail()
+	v1 := []ColumnInfo{{ColumnName: "id"}, {ColumnName: "name"}}
+	v2 := []ColumnInfo{{ColumnName: "id"}, {ColumnName: "age"}, {ColumnName: "name"}}
+	s.schemaHist.Record("db.t1", mysql.Position{Name: "binlog.000001", Pos: 100}, v1)
+	s.schemaHist.OnDDL("db.t1", mysql.Position{Name: "binlog.000001", Pos: 200}, v2)
+
+	rows := func(logPos uint32, columnCount uint64) (*replication.BinlogEvent, *replication.RowsEvent) {
+		re := &replication.RowsEvent{Table: &replication.TableMapEvent{Schema: []byte("db"), Table: []byte("t1"), ColumnCount: columnCount}}
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, LogPos: logPos}, Event: re}, re
+	}
+
+	ev, re := rows(150, 2)
+	if ti, err := s.resolveTableInfo(rail, ev, re); err != nil || len(ti.Columns) != 2 {
+		t.Fatalf("should use the version before DDL, %+v, %v", ti, err)
+	}
+	ev, re = rows(300, 3)
+	if ti, err := s.resolveTableInfo(rail, ev, re); err != nil || len(ti.Columns) != 3 {
+		t.Fatalf("should use the version after DDL, %+v, %v", ti, err)
+	}
+	ev, re = rows(300, 2)
+	if _, err := s.resolveTableInfo(rail, ev, re); err == nil {
+		t.Fatal("column count doesn't match, should fail")
+	}
+
+	// fetched from information_schema, but the table has been changed
+	s.tableInfoMap["db.t2"] = TableInfo{Schema: "db", Table: "t2", Columns: v2}
+	ev, re = rows(300, 2)
+	re.Table.Table = []byte("t2")
+	if _, err := s.resolveTableInfo(rail, ev, re); err == nil {
+		t.Fatal("column count doesn't match, should fail")
+	}
+	if _, ok := s.schemaHist.Lookup("db.t2", mysql.Position{Name: "binlog.000001", Pos: 300}); ok {
+		t.Fatal("mismatched table definition should not be recorded")
+	}
+	if _, ok := s.tableInfoMap["db.t2"]; ok {
+		t.Fatal("mismatched table definition should not be cached")
+	}
+}
+
+func TestHandleDDLEvent(t *testing.T) {
+	s := newTestSource(t, flavorMysql)
+	s.nextPos = BinlogPos{Position: mysql.Position{Name: "binlog.000001", Pos: 4}}
+	s.tableInfoMap["db.t1"] = TableInfo{Schema: "db", Table: "t1", Columns: []ColumnInfo{
+		{ColumnName: "id", DataType: "bigint", PrimaryKey: true},
+		{ColumnName: "name", DataType: "varchar"},
+	}}
+
+	var received []DataChangeEvent
+	id := OnEventReceived(func(c miso.Rail, dce DataChangeEvent, ctx *EventHandleContext) error {
+		received = append(received, dce)
+		return nil
+	})
+	defer RemoveEventHandler(id)
+
+	query := func(q string, logPos uint32) {
+		ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.QUERY_EVENT, LogPos: logPos},
+			Event: &replication.QueryEvent{Schema: []byte("db"), Query: []byte(q)}}
+		if err := s.handleEvent(miso.EmptyRail(), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(cols []RecordColumn) []string {
+		n := []string{}
+		for _, c := range cols {
+			n = append(n, c.Name+":"+c.DataType)
+		}
+		return n
+	}
+
+	// columns after the DDL are derived from the statement, information_schema is not used (not connected)
+	query("alter table t1 add column age int unsigned after id, modify name text", 200)
+	query("alter table t1 drop column age", 300)
+	query("alter table t2 add column age int", 400) // columns before the DDL are unknown
+	query("rename table t1 to t3", 500)
+
+	expected := []struct {
+		table      string
+		oldColumns []string
+		columns    []string
+	}{
+		{table: "t1", oldColumns: []string{"id:bigint", "name:varchar"}, columns: []string{"id:bigint", "age:int", "name:text"}},
+		{table: "t1", oldColumns: []string{"id:bigint", "age:int", "name:text"}, columns: []string{"id:bigint", "name:text"}},
+		{table: "t2", oldColumns: []string{}, columns: []string{}},
+		{table: "t3", oldColumns: []string{}, columns: []string{"id:bigint", "name:text"}},
+		{table: "t1", oldColumns: []string{"id:bigint", "name:text"}, columns: []string{}},
+	}
+	if len(received) != len(expected) {
+		t.Fatalf("expected %d events, received: %v", len(expected), received)
+	}
+	for i, v := range expected {
+		dce := received[i]
+		if dce.Type != TypeDDL || dce.Schema != "db" || dce.Table != v.table ||
+			!slices.Equal(names(dce.OldColumns), v.oldColumns) || !slices.Equal(names(dce.Columns), v.columns) {
+			t.Fatalf("%d, %v", i, dce)
+		}
+	}
+	if !received[0].Columns[1].Unsigned {
+		t.Fatalf("%+v", received[0].Columns)
+	}
+
+	// rows written before the DDL are still decoded using the old columns
+	re := &replication.RowsEvent{Table: &replication.TableMapEvent{Schema: []byte("db"), Table: []byte("t1"), ColumnCount: 3}}
+	ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, LogPos: 250}, Event: re}
+	if ti, err := s.resolveTableInfo(miso.EmptyRail(), ev, re); err != nil || ti.Columns[1].ColumnName != "age" {
+		t.Fatalf("%+v, %v", ti, err)
+	}
+}
+
+func TestParseBinlogPos(t *testing.T) {
+	pos, err := parseBinlogPos(`{"Name":"binlog.000001","Pos":53318}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.Name != "binlog.000001" || pos.Pos != 53318 || pos.GTID != "" {
+		t.Fatalf("%+v", pos)
+	}
+
+	pos, err = parseBinlogPos(`{"Name":"binlog.000002","Pos":4,"GTID":"3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.Name != "binlog.000002" || pos.Pos != 4 || pos.GTID != "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5" {
+		t.Fatalf("%+v", pos)
+	}
+}
+
+func TestConvertUnsigned(t *testing.T) {
+	columns := []ColumnInfo{
+		{ColumnName: "id", DataType: "bigint", Unsigned: true},
+		{ColumnName: "flag", DataType: "tinyint", Unsigned: true},
+		{ColumnName: "cnt", DataType: "mediumint", Unsigned: true},
+		{ColumnName: "num", DataType: "int", Unsigned: true},
+		{ColumnName: "delta", DataType: "int"},
+	}
+	row := []any{int64(-1), int8(-1), int32(-1), int32(-1), int32(-1)}
+	convertUnsigned(columns, row)
+
+	expected := []any{uint64(18446744073709551615), uint8(255), uint32(16777215), uint32(4294967295), int32(-1)}
+	for i := range expected {
+		if row[i] != expected[i] {
+			t.Fatalf("row[%d] should be %v (%T), but got %v (%T)", i, expected[i], expected[i], row[i], row[i])
+		}
+	}
+}
+
+func TestParseColumnTypeLabels(t *testing.T) {
+	tab := []struct {
+		columnType string
+		expected   []string
+	}{
+		{"enum('PENDING','PAID','CANCELLED')", []string{"PENDING", "PAID", "CANCELLED"}},
+		{"set('a','b,c','it''s')", []string{"a", "b,c", "it's"}},
+		{"enum('')", []string{""}},
+		{"varchar(255)", []string{}},
+	}
+	for _, c := range tab {
+		v := parseColumnTypeLabels(c.columnType)
+		if !reflect.DeepEqual(v, c.expected) {
+			t.Fatalf("%v, expected %#v, but got %#v", c.columnType, c.expected, v)
+		}
+	}
+}
+
+func TestResolveEnumSetLabels(t *testing.T) {
+	columns := []ColumnInfo{
+		{ColumnName: "status", DataType: "enum", EnumValues: []string{"PENDING", "PAID"}},
+		{ColumnName: "tags", DataType: "set", SetValues: []string{"a", "b", "c"}},
+		{ColumnName: "invalid", DataType: "enum", EnumValues: []string{"PENDING", "PAID"}},
+		{ColumnName: "num", DataType: "bigint"},
+	}
+	row := []any{int64(2), int64(5), int64(0), int64(3)}
+	resolveEnumSetLabels(columns, row)
 
-	if ok {
-		t.FailNow()
+	expected := []any{"PAID", "a,c", "", int64(3)}
+	if !reflect.DeepEqual(row, expected) {
+		t.Fatalf("expected %#v, but got %#v", expected, row)
 	}
 }
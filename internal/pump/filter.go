@@ -23,13 +23,16 @@ type columnFilter struct {
 func (f columnFilter) Include(rail miso.Rail, evt any) bool {
 	switch ev := evt.(type) {
 	case StreamEvent:
+		if ev.Type == TypeDDL && ev.DDL != nil {
+			return f.includeDDL(rail, ev.DDL)
+		}
 		if ev.Type != TypeUpdate {
 			return false
 		}
 
 		for _, cc := range f.ColumnsChanged {
 			sec, ok := ev.Columns[cc]
-			if ok && sec.Before != sec.After {
+			if ok && sec.Changed() {
 				rail.Debugf("Event included, contains change to the specified columns: %v", f.ColumnsChanged)
 				return true
 			}
@@ -38,6 +41,25 @@ func (f columnFilter) Include(rail miso.Rail, evt any) bool {
 		rail.Debugf("Event filtered out, doesn't contain change to any of the specified columns: %v", f.ColumnsChanged)
 		return false // the event doesn't include any change to these specified columns
 
+	case StreamEventV2:
+		if ev.Type == TypeDDL && ev.DDL != nil {
+			return f.includeDDL(rail, ev.DDL)
+		}
+		if ev.Type != TypeUpdate {
+			return false
+		}
+
+		for _, cc := range f.ColumnsChanged {
+			sec, ok := ev.Columns[cc]
+			if ok && sec.Changed() {
+				rail.Debugf("Event included, contains change to the specified columns: %v", f.ColumnsChanged)
+				return true
+			}
+		}
+
+		rail.Debugf("Event filtered out, doesn't contain change to any of the specified columns: %v", f.ColumnsChanged)
+		return false
+
 	case DataChangeEvent:
 		return false // doesn't support at all
 	}
@@ -45,6 +67,27 @@ func (f columnFilter) Include(rail miso.Rail, evt any) bool {
 	return true
 }
 
+// DDL is included if any of the specified columns is added, dropped or has its data type changed.
+func (f columnFilter) includeDDL(rail miso.Rail, ddl *StreamEventDDL) bool {
+	find := func(cols []RecordColumn, name string) (RecordColumn, bool) {
+		for _, c := range cols {
+			if c.Name == name {
+				return c, true
+			}
+		}
+		return RecordColumn{}, false
+	}
+	for _, cc := range f.ColumnsChanged {
+		oc, ook := find(ddl.OldColumns, cc)
+		nc, nok := find(ddl.NewColumns, cc)
+		if ook != nok || oc != nc {
+			rail.Debugf("DDL included, contains change to the specified columns: %v", f.ColumnsChanged)
+			return true
+		}
+	}
+	return false
+}
+
 func NewFilters(p Pipeline) []Filter {
 	if len(p.Condition.ColumnChanged) < 1 {
 		return []Filter{noOpFilter{}}
@@ -0,0 +1,37 @@
+package pump
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestColumnFilterIncludeDDL(t *testing.T) {
+	id := RecordColumn{Name: "id", DataType: "bigint", PrimaryKey: true}
+	name := RecordColumn{Name: "name", DataType: "varchar"}
+	f := columnFilter{ColumnsChanged: []string{"name"}}
+
+	tab := []struct {
+		name       string
+		oldColumns []RecordColumn
+		newColumns []RecordColumn
+		included   bool
+	}{
+		{name: "unchanged", oldColumns: []RecordColumn{id, name}, newColumns: []RecordColumn{id, name}, included: false},
+		{name: "other column added", oldColumns: []RecordColumn{id, name}, newColumns: []RecordColumn{id, name, {Name: "age", DataType: "int"}}, included: false},
+		{name: "added", oldColumns: []RecordColumn{id}, newColumns: []RecordColumn{id, name}, included: true},
+		{name: "dropped", oldColumns: []RecordColumn{id, name}, newColumns: []RecordColumn{id}, included: true},
+		{name: "type changed", oldColumns: []RecordColumn{id, name}, newColumns: []RecordColumn{id, {Name: "name", DataType: "text"}}, included: true},
+		{name: "columns unknown", oldColumns: []RecordColumn{id, name}, newColumns: nil, included: true},
+	}
+	for _, v := range tab {
+		ev := StreamEvent{Type: TypeDDL, DDL: &StreamEventDDL{OldColumns: v.oldColumns, NewColumns: v.newColumns}}
+		if f.Include(miso.EmptyRail(), ev) != v.included {
+			t.Fatalf("%v, expected included: %v", v.name, v.included)
+		}
+		ev2 := StreamEventV2{Type: TypeDDL, DDL: &StreamEventDDL{OldColumns: v.oldColumns, NewColumns: v.newColumns}}
+		if f.Include(miso.EmptyRail(), ev2) != v.included {
+			t.Fatalf("%v (v2), expected included: %v", v.name, v.included)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package pump
+
+import (
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	PropSyncGTIDEnabled = "sync.gtid.enabled"
+)
+
+func init() {
+	miso.SetDefProp(PropSyncGTIDEnabled, false)
+}
+
+func (s *Source) resetGTIDSet(gset mysql.GTIDSet) {
+	s.gtidSet = gset
+	s.pendingGTID = nil
+}
+
+// Track GTID of the transaction, the GTID is only added to the executed GTID set when the transaction is committed,
+// see commitTx().
+func (s *Source) trackGTID(rail miso.Rail, ev *replication.BinlogEvent, txEnded bool) {
+	if s.gtidSet == nil {
+		return
+	}
+
+	switch t := ev.Event.(type) {
+	case *replication.GTIDEvent:
+		if ev.Header.EventType == replication.ANONYMOUS_GTID_EVENT {
+			return
+		}
+		next, err := t.GTIDNext()
+		if err != nil {
+			rail.Errorf("Failed to parse GTID, %v", err)
+			return
+		}
+		s.pendingGTID = next
+	case *replication.MariadbGTIDEvent:
+		next, err := t.GTIDNext()
+		if err != nil {
+			rail.Errorf("Failed to parse MariaDB GTID, %v", err)
+			return
+		}
+		s.pendingGTID = next
+	}
+
+	if txEnded {
+		s.commitGTID(rail)
+	}
+}
+
+func (s *Source) commitGTID(rail miso.Rail) {
+	if s.pendingGTID == nil {
+		return
+	}
+	if err := s.gtidSet.Update(s.pendingGTID.String()); err != nil {
+		rail.Errorf("Failed to update executed GTID set with '%v', %v", s.pendingGTID, err)
+		return
+	}
+	s.pendingGTID = nil
+	s.updateGTID(rail, s.gtidSet.String())
+}
@@ -0,0 +1,99 @@
+package pump
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+)
+
+func newTestSource(t *testing.T, flavor string) *Source {
+	s, err := NewSource(SourceConfig{Name: "test", Flavor: flavor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// feed event in the same order as PumpEvents()
+func feedTxEvent(t *testing.T, s *Source, ev *replication.BinlogEvent) {
+	rail := miso.EmptyRail()
+	s.beginTx(rail, ev)
+	if re, ok := ev.Event.(*replication.RowsEvent); ok {
+		dce := DataChangeEvent{Records: make([]Record, len(re.Rows))}
+		s.assignTx(ev, &dce)
+	}
+	ended, err := s.commitTx(rail, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.trackGTID(rail, ev, ended)
+}
+
+func testEvent(typ replication.EventType, e replication.Event) *replication.BinlogEvent {
+	return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: typ}, Event: e}
+}
+
+func TestTrackGTID(t *testing.T) {
+	sid := uuid.MustParse("3e11fa47-71ca-11e1-9e33-c80aa9429562")
+
+	gset, err := mysql.ParseGTIDSet(flavorMysql, sid.String()+":1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSource(t, flavorMysql)
+	s.resetGTIDSet(gset)
+
+	gtidEvent := func(gno int64) *replication.BinlogEvent {
+		return testEvent(replication.GTID_EVENT, &replication.GTIDEvent{SID: sid[:], GNO: gno})
+	}
+	queryEvent := func(q string) *replication.BinlogEvent {
+		return testEvent(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte(q)})
+	}
+
+	feedTxEvent(t, s, gtidEvent(6))
+	feedTxEvent(t, s, queryEvent("BEGIN"))
+	feedTxEvent(t, s, testEvent(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{Rows: [][]any{{1}, {2}}}))
+	feedTxEvent(t, s, queryEvent("SAVEPOINT sp1"))
+	if s.nextPos.GTID == sid.String()+":1-6" {
+		t.Fatal("GTID should not be committed before XID")
+	}
+	if s.currTx.Id != sid.String()+":6" || s.currTx.Seq != 2 {
+		t.Fatalf("%+v", s.currTx)
+	}
+	feedTxEvent(t, s, testEvent(replication.XID_EVENT, &replication.XIDEvent{}))
+	if s.nextPos.GTID != sid.String()+":1-6" {
+		t.Fatalf("GTID not committed, %v", s.nextPos.GTID)
+	}
+	if s.currTx.Active {
+		t.Fatal("transaction should be ended")
+	}
+
+	feedTxEvent(t, s, gtidEvent(7))
+	feedTxEvent(t, s, queryEvent("alter table my_table add column name varchar(10)"))
+	if s.nextPos.GTID != sid.String()+":1-7" {
+		t.Fatalf("GTID not committed, %v", s.nextPos.GTID)
+	}
+}
+
+func TestTrackMariaDBGTID(t *testing.T) {
+	gset, err := mysql.ParseGTIDSet(flavorMariaDB, "0-1-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSource(t, flavorMariaDB)
+	s.resetGTIDSet(gset)
+
+	feedTxEvent(t, s, testEvent(replication.MARIADB_GTID_EVENT,
+		&replication.MariadbGTIDEvent{GTID: mysql.MariadbGTID{DomainID: 0, ServerID: 1, SequenceNumber: 11}}))
+	feedTxEvent(t, s, testEvent(replication.WRITE_ROWS_EVENTv1, &replication.RowsEvent{Rows: [][]any{{1}}}))
+	if s.currTx.Id != "0-1-11" {
+		t.Fatalf("%+v", s.currTx)
+	}
+	feedTxEvent(t, s, testEvent(replication.XID_EVENT, &replication.XIDEvent{}))
+	if s.nextPos.GTID != "0-1-11" {
+		t.Fatalf("GTID not committed, %v", s.nextPos.GTID)
+	}
+}
@@ -0,0 +1,94 @@
+package pump
+
+import (
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	PropSyncHealthMaxLag  = "sync.health.max-lag"
+	PropSyncHealthMaxIdle = "sync.health.max-idle"
+)
+
+func init() {
+	miso.SetDefProp(PropSyncHealthMaxLag, 300)
+	miso.SetDefProp(PropSyncHealthMaxIdle, 60)
+}
+
+// Record the time when the binlog event is received, and the replication lag of the event.
+//
+// Lag is the difference between the event header timestamp and wall-clock time,
+// heartbeat events are only sent by master when there are no more binlog events, so the lag is reset to zero.
+func (s *Source) observeLag(ev *replication.BinlogEvent) {
+	now := time.Now()
+
+	s.posMu.Lock()
+	defer s.posMu.Unlock()
+
+	s.lastBinlogTime = now
+
+	switch ev.Header.EventType {
+	case replication.HEARTBEAT_EVENT, replication.HEARTBEAT_LOG_EVENT_V2:
+		s.lag = 0
+	case replication.ROTATE_EVENT, replication.FORMAT_DESCRIPTION_EVENT:
+		// artificial events or events describing the binlog file, timestamp is not the time of change
+		return
+	default:
+		if ev.Header.Timestamp == 0 {
+			return
+		}
+		s.lag = max(now.Sub(time.Unix(int64(ev.Header.Timestamp), 0)), 0)
+	}
+	binlogLagGauge.WithLabelValues(s.Name).Set(s.lag.Seconds())
+}
+
+// Mark the beginning of binlog streaming, idle time is measured since then if no binlog event is received.
+func (s *Source) resetLag() {
+	s.posMu.Lock()
+	defer s.posMu.Unlock()
+	s.lastBinlogTime = time.Now()
+	s.lag = 0
+	binlogLagGauge.WithLabelValues(s.Name).Set(0)
+}
+
+func (s *Source) checkBinlogHealth() bool {
+	if s.failed.Load() { // stream is stopped because of error
+		return false
+	}
+
+	s.posMu.Lock()
+	defer s.posMu.Unlock()
+
+	if s.lastBinlogTime.IsZero() { // not streaming yet
+		return true
+	}
+
+	now := time.Now()
+	idle := now.Sub(s.lastBinlogTime)
+	maxLag := time.Duration(miso.GetPropInt(PropSyncHealthMaxLag)) * time.Second
+	maxIdle := time.Duration(miso.GetPropInt(PropSyncHealthMaxIdle)) * time.Second
+	if binlogHealthy(s.lag, idle, maxLag, maxIdle) {
+		return true
+	}
+
+	if now.After(s.lastBinlogWarnTime.Add(binlogWarnInterval)) {
+		s.lastBinlogWarnTime = now
+		miso.Warnf("Binlog streaming of source '%v' is unhealthy, lag: %v, last time binlog event received was %v, currPos: %+v",
+			s.Name, s.lag, s.lastBinlogTime.Format(util.StdDateTimeMilliFormat), s.currPos)
+	}
+	return false
+}
+
+// Check lag and idle time against the thresholds, threshold <= 0 is disabled.
+func binlogHealthy(lag time.Duration, idle time.Duration, maxLag time.Duration, maxIdle time.Duration) bool {
+	if maxLag > 0 && lag > maxLag {
+		return false
+	}
+	if maxIdle > 0 && idle > maxIdle {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package pump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestBinlogHealthy(t *testing.T) {
+	maxLag := time.Minute * 5
+	maxIdle := time.Minute
+	if !binlogHealthy(time.Second, time.Second*5, maxLag, maxIdle) {
+		t.Fatal("should be healthy")
+	}
+	if binlogHealthy(time.Minute*10, time.Second, maxLag, maxIdle) {
+		t.Fatal("lagging, should be unhealthy")
+	}
+	if binlogHealthy(0, time.Minute*2, maxLag, maxIdle) {
+		t.Fatal("idle for too long, should be unhealthy")
+	}
+	if !binlogHealthy(time.Hour, time.Hour, 0, 0) {
+		t.Fatal("thresholds are disabled, should be healthy")
+	}
+}
+
+func TestObserveLag(t *testing.T) {
+	s := newTestSource(t, flavorMysql)
+	if !s.checkBinlogHealth() {
+		t.Fatal("should be healthy before streaming")
+	}
+
+	ev := testEvent(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{})
+	ev.Header.Timestamp = uint32(time.Now().Add(-time.Minute * 10).Unix())
+	s.observeLag(ev)
+	if s.lag < time.Minute*10 || s.lastBinlogTime.IsZero() {
+		t.Fatalf("incorrect lag: %v", s.lag)
+	}
+	if s.checkBinlogHealth() {
+		t.Fatal("lagging, should be unhealthy")
+	}
+
+	// artificial rotate event doesn't have meaningful timestamp
+	s.observeLag(testEvent(replication.ROTATE_EVENT, &replication.RotateEvent{}))
+	if s.lag < time.Minute*10 {
+		t.Fatalf("incorrect lag: %v", s.lag)
+	}
+
+	// caught up
+	s.observeLag(testEvent(replication.HEARTBEAT_EVENT, &replication.GenericEvent{}))
+	if s.lag != 0 {
+		t.Fatalf("incorrect lag: %v", s.lag)
+	}
+	if !s.checkBinlogHealth() {
+		t.Fatal("should be healthy")
+	}
+}
@@ -0,0 +1,534 @@
+package pump
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	JsonPatchReplace = "replace"
+	JsonPatchInsert  = "insert"
+	JsonPatchRemove  = "remove"
+)
+
+// JSON patch operation of partial JSON update (binlog_row_value_options=PARTIAL_JSON).
+//
+// Path is MySQL JSON path, e.g., '$.name', '$.items[0]', op has the same effect as JSON_REPLACE, JSON_INSERT (or JSON_ARRAY_INSERT) and JSON_REMOVE.
+type JsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func newJsonPatchOp(d *replication.JsonDiff) JsonPatchOp {
+	op := JsonPatchOp{Path: d.Path}
+	switch d.Op {
+	case replication.JsonDiffOperationReplace:
+		op.Op = JsonPatchReplace
+	case replication.JsonDiffOperationInsert:
+		op.Op = JsonPatchInsert
+	case replication.JsonDiffOperationRemove:
+		op.Op = JsonPatchRemove
+	}
+	if d.Op != replication.JsonDiffOperationRemove {
+		op.Value = json.RawMessage(d.Value)
+	}
+	return op
+}
+
+// Rebuild the after image of JSON columns that are partially updated (PARTIAL_UPDATE_ROWS_EVENT).
+//
+// The JsonDiffs are applied to the before image in order, if the before image is not available or the diffs can't be applied,
+// the after value is marked absent, and the patch operations are recorded in Record.AfterJsonPatch instead.
+func rebuildPartialJson(rec *Record) {
+	for j, v := range rec.After {
+		var diffs []*replication.JsonDiff
+		switch d := v.(type) {
+		case *replication.JsonDiff:
+			diffs = []*replication.JsonDiff{d}
+		case []*replication.JsonDiff:
+			diffs = d
+		default:
+			continue
+		}
+		ops := make([]JsonPatchOp, 0, len(diffs))
+		for _, d := range diffs {
+			ops = append(ops, newJsonPatchOp(d))
+		}
+		if rec.HasBefore(j) {
+			if before, ok := jsonText(rec.Before[j]); ok {
+				if after, err := applyJsonPatches(before, ops); err == nil {
+					rec.After[j] = after
+					continue
+				}
+			}
+		}
+		rec.After[j] = nil
+		rec.AfterAbsent = append(rec.AfterAbsent, j)
+		if rec.AfterJsonPatch == nil {
+			rec.AfterJsonPatch = map[int][]JsonPatchOp{}
+		}
+		rec.AfterJsonPatch[j] = ops
+	}
+}
+
+// Partially updated JSON column in the rows data of PARTIAL_UPDATE_ROWS_EVENT.
+type jsonDiffVector struct {
+	row   int      // index of the after image in RowsEvent.Rows
+	col   int      // column index
+	start int      // start of the value (including the length bytes) in rows data
+	end   int      // end of the value in rows data
+	diffs [][]byte // binary diffs in the vector
+}
+
+// Decode the full JSON diff vectors of the partially updated JSON columns.
+//
+// Each partially updated JSON column is a vector of diffs, e.g., JSON_SET(doc, '$.a', 1, '$.b', 2) writes two diffs,
+// but go-mysql only decodes the first diff of the vector. The rows data is walked to locate the vectors,
+// and the event is decoded again for each of the remaining diffs, with the vectors rewritten to only contain that diff.
+// Values of the columns with more than one diff are replaced with []*replication.JsonDiff.
+func decodeJsonDiffVectors(ev *replication.BinlogEvent, re *replication.RowsEvent) (err error) {
+	if len(ev.RawData) <= replication.EventHeaderSize || re.Table == nil || re.Table.JsonColumnCount() < 1 {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to decode json diff vectors, malformed rows data, %v", r)
+		}
+	}()
+
+	data := ev.RawData[replication.EventHeaderSize:]
+	h := *re
+	pos, err := h.DecodeHeader(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode rows event header, %w", err)
+	}
+
+	var vectors []jsonDiffVector
+	maxDiffs := 1
+	for i := 1; i < len(re.Rows); i += 2 {
+		if pos, _, err = walkRowImage(data, pos, re, re.ColumnBitmap1, false); err != nil {
+			return err
+		}
+		var cols []jsonDiffVector
+		if pos, cols, err = walkRowImage(data, pos, re, re.ColumnBitmap2, true); err != nil {
+			return err
+		}
+		for _, v := range cols {
+			v.row = i
+			maxDiffs = max(maxDiffs, len(v.diffs))
+			vectors = append(vectors, v)
+		}
+	}
+	if maxDiffs < 2 {
+		return nil
+	}
+
+	decoded := make([][]*replication.JsonDiff, len(vectors))
+	for k, v := range vectors {
+		d, ok := re.Rows[v.row][v.col].(*replication.JsonDiff)
+		if !ok {
+			return fmt.Errorf("json diff is not decoded, row: %d, column: %d", v.row, v.col)
+		}
+		decoded[k] = append(decoded[k], d)
+	}
+
+	for m := 1; m < maxDiffs; m++ {
+		// the vectors with fewer diffs keep their first diff, the decoded values are simply ignored
+		buf := make([]byte, 0, pos)
+		last := 0
+		for _, v := range vectors {
+			d := v.diffs[0]
+			if m < len(v.diffs) {
+				d = v.diffs[m]
+			}
+			buf = append(buf, data[last:v.start]...)
+			for b := 0; b < int(re.Table.ColumnMeta[v.col]); b++ {
+				buf = append(buf, byte(len(d)>>(8*b)))
+			}
+			buf = append(buf, d...)
+			last = v.end
+		}
+		buf = append(buf, data[last:pos]...)
+
+		cp := *re
+		if err := cp.Decode(buf); err != nil {
+			return fmt.Errorf("failed to decode json diff vectors, %w", err)
+		}
+		for k, v := range vectors {
+			if m >= len(v.diffs) {
+				continue
+			}
+			d, ok := cp.Rows[v.row][v.col].(*replication.JsonDiff)
+			if !ok {
+				return fmt.Errorf("json diff is not decoded, row: %d, column: %d", v.row, v.col)
+			}
+			decoded[k] = append(decoded[k], d)
+		}
+	}
+
+	for k, v := range vectors {
+		if len(v.diffs) > 1 {
+			re.Rows[v.row][v.col] = decoded[k]
+		}
+	}
+	return nil
+}
+
+// Walk a row image in rows data, returns the end of the image and the partially updated JSON columns.
+//
+// The layout is the same as RowsEvent.decodeImage in go-mysql.
+func walkRowImage(data []byte, pos int, re *replication.RowsEvent, bitmap []byte, afterImage bool) (int, []jsonDiffVector, error) {
+	var partialBitmap []byte
+	if afterImage {
+		opts, _, n := mysql.LengthEncodedInt(data[pos:]) // binlog_row_value_options
+		pos += n
+		if replication.EnumBinlogRowValueOptions(opts)&replication.EnumBinlogRowValueOptionsPartialJsonUpdates != 0 {
+			cnt := int(re.Table.JsonColumnCount()+7) / 8
+			partialBitmap = data[pos : pos+cnt]
+			pos += cnt
+		}
+	}
+
+	present := 0
+	for i := 0; i < int(re.ColumnCount); i++ {
+		if isBitSet(bitmap, i) {
+			present++
+		}
+	}
+	nullBitmap := data[pos : pos+(present+7)/8]
+	pos += len(nullBitmap)
+
+	var vectors []jsonDiffVector
+	jsonIdx, nullIdx := 0, 0
+	for i := 0; i < int(re.ColumnCount); i++ {
+		tp := re.Table.ColumnType[i]
+		partial := false
+		if partialBitmap != nil && tp == mysql.MYSQL_TYPE_JSON {
+			partial = isBitSet(partialBitmap, jsonIdx)
+			jsonIdx++
+		}
+		if !isBitSet(bitmap, i) {
+			continue
+		}
+		null := isBitSet(nullBitmap, nullIdx)
+		nullIdx++
+		if null {
+			continue
+		}
+
+		n, err := rowValueLen(data[pos:], tp, re.Table.ColumnMeta[i])
+		if err != nil {
+			return 0, nil, err
+		}
+		if partial {
+			meta := int(re.Table.ColumnMeta[i])
+			if diffs, err := splitJsonDiffs(data[pos+meta : pos+n]); err != nil {
+				return 0, nil, err
+			} else if len(diffs) > 0 {
+				vectors = append(vectors, jsonDiffVector{col: i, start: pos, end: pos + n, diffs: diffs})
+			}
+		}
+		pos += n
+	}
+	return pos, vectors, nil
+}
+
+// Split the binary JSON diff vector, see Json_diff_vector::read_binary() in mysql-server.
+func splitJsonDiffs(vec []byte) ([][]byte, error) {
+	var diffs [][]byte
+	for len(vec) > 0 {
+		op := replication.JsonDiffOperation(vec[0])
+		if op > replication.JsonDiffOperationRemove {
+			return nil, replication.ErrCorruptedJSONDiff
+		}
+		i := 1
+		pathLen, _, n := mysql.LengthEncodedInt(vec[i:])
+		i += n + int(pathLen)
+		if op != replication.JsonDiffOperationRemove {
+			valueLen, _, n := mysql.LengthEncodedInt(vec[i:])
+			i += n + int(valueLen)
+		}
+		if i > len(vec) {
+			return nil, replication.ErrCorruptedJSONDiff
+		}
+		diffs = append(diffs, vec[:i])
+		vec = vec[i:]
+	}
+	return diffs, nil
+}
+
+var decimalCompressedBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// Length of the column value in rows data, see RowsEvent.decodeValue in go-mysql.
+func rowValueLen(data []byte, tp byte, meta uint16) (int, error) {
+	length := 0
+	if tp == mysql.MYSQL_TYPE_STRING {
+		if meta >= 256 {
+			b0 := uint8(meta >> 8)
+			if b0&0x30 != 0x30 {
+				length = int(uint16(meta&0xFF) | (uint16((b0&0x30)^0x30) << 4))
+				tp = b0 | 0x30
+			} else {
+				length = int(meta & 0xFF)
+				tp = b0
+			}
+		} else {
+			length = int(meta)
+		}
+	}
+
+	switch tp {
+	case mysql.MYSQL_TYPE_NULL:
+		return 0, nil
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_YEAR:
+		return 1, nil
+	case mysql.MYSQL_TYPE_SHORT:
+		return 2, nil
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_TIME:
+		return 3, nil
+	case mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_TIMESTAMP:
+		return 4, nil
+	case mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE, mysql.MYSQL_TYPE_DATETIME:
+		return 8, nil
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		integral := int(meta>>8) - int(meta&0xFF)
+		scale := int(meta & 0xFF)
+		return integral/9*4 + decimalCompressedBytes[integral%9] + scale/9*4 + decimalCompressedBytes[scale%9], nil
+	case mysql.MYSQL_TYPE_BIT:
+		nbits := (meta>>8)*8 + (meta & 0xFF)
+		return int(nbits+7) / 8, nil
+	case mysql.MYSQL_TYPE_TIMESTAMP2:
+		return 4 + int(meta+1)/2, nil
+	case mysql.MYSQL_TYPE_DATETIME2:
+		return 5 + int(meta+1)/2, nil
+	case mysql.MYSQL_TYPE_TIME2:
+		return 3 + int(meta+1)/2, nil
+	case mysql.MYSQL_TYPE_ENUM, mysql.MYSQL_TYPE_SET:
+		return int(meta & 0xFF), nil
+	case mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_GEOMETRY, mysql.MYSQL_TYPE_JSON:
+		return int(mysql.FixedLengthInt(data[:meta])) + int(meta), nil
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		length = int(meta)
+		fallthrough
+	case mysql.MYSQL_TYPE_STRING:
+		if length < 256 {
+			return int(data[0]) + 1, nil
+		}
+		return int(mysql.FixedLengthInt(data[:2])) + 2, nil
+	}
+	return 0, fmt.Errorf("unsupported column type %d in rows event", tp)
+}
+
+func isBitSet(bitmap []byte, i int) bool {
+	return bitmap[i>>3]&(1<<(uint(i)&7)) > 0
+}
+
+func jsonText(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case []byte:
+		return string(t), true
+	}
+	return "", false
+}
+
+type jsonPathLeg struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// Parse simple MySQL JSON path, only member and array index are supported, e.g., '$.a."b c"[1]'.
+func parseJsonPath(p string) ([]jsonPathLeg, error) {
+	p = strings.TrimSpace(p)
+	if !strings.HasPrefix(p, "$") {
+		return nil, fmt.Errorf("invalid json path: '%v'", p)
+	}
+	legs := []jsonPathLeg{}
+	rest := p[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, `"`) {
+				end := 1
+				for end < len(rest) && (rest[end] != '"' || rest[end-1] == '\\') {
+					end++
+				}
+				if end >= len(rest) {
+					return nil, fmt.Errorf("invalid json path: '%v'", p)
+				}
+				key, err := strconv.Unquote(rest[:end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid json path: '%v', %w", p, err)
+				}
+				legs = append(legs, jsonPathLeg{key: key})
+				rest = rest[end+1:]
+			} else {
+				end := strings.IndexAny(rest, ".[")
+				if end < 0 {
+					end = len(rest)
+				}
+				key := rest[:end]
+				if key == "" || key == "*" {
+					return nil, fmt.Errorf("unsupported json path: '%v'", p)
+				}
+				legs = append(legs, jsonPathLeg{key: key})
+				rest = rest[end:]
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid json path: '%v'", p)
+			}
+			idx, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("unsupported json path: '%v'", p)
+			}
+			legs = append(legs, jsonPathLeg{index: idx, isIdx: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid json path: '%v'", p)
+		}
+	}
+	return legs, nil
+}
+
+// Apply the patch operations to the json document in order.
+func applyJsonPatches(doc string, ops []JsonPatchOp) (string, error) {
+	var err error
+	for _, op := range ops {
+		if doc, err = applyJsonPatch(doc, op); err != nil {
+			return "", err
+		}
+	}
+	return doc, nil
+}
+
+// Apply the patch operation to the json document.
+func applyJsonPatch(doc string, op JsonPatchOp) (string, error) {
+	legs, err := parseJsonPath(op.Path)
+	if err != nil {
+		return "", err
+	}
+	root, err := decodeJson(doc)
+	if err != nil {
+		return "", err
+	}
+	var val any
+	if op.Op != JsonPatchRemove {
+		if val, err = decodeJson(string(op.Value)); err != nil {
+			return "", err
+		}
+	}
+
+	if len(legs) < 1 {
+		if op.Op != JsonPatchReplace {
+			return "", fmt.Errorf("can't %v the root of json document", op.Op)
+		}
+		return encodeJson(val)
+	}
+
+	updated, err := patchJsonValue(root, legs, op.Op, val)
+	if err != nil {
+		return "", err
+	}
+	return encodeJson(updated)
+}
+
+func patchJsonValue(node any, legs []jsonPathLeg, op string, val any) (any, error) {
+	leg := legs[0]
+	last := len(legs) == 1
+
+	if leg.isIdx {
+		arr, ok := node.([]any)
+		if !ok {
+			return nil, errors.New("json path doesn't match the document, array is expected")
+		}
+		if !last {
+			if leg.index >= len(arr) {
+				return node, nil // path doesn't exist, nothing to do
+			}
+			v, err := patchJsonValue(arr[leg.index], legs[1:], op, val)
+			if err != nil {
+				return nil, err
+			}
+			arr[leg.index] = v
+			return arr, nil
+		}
+		switch op {
+		case JsonPatchReplace:
+			if leg.index < len(arr) {
+				arr[leg.index] = val
+			}
+		case JsonPatchInsert:
+			i := min(leg.index, len(arr))
+			arr = append(arr[:i], append([]any{val}, arr[i:]...)...)
+		case JsonPatchRemove:
+			if leg.index < len(arr) {
+				arr = append(arr[:leg.index], arr[leg.index+1:]...)
+			}
+		}
+		return arr, nil
+	}
+
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil, errors.New("json path doesn't match the document, object is expected")
+	}
+	if !last {
+		child, ok := obj[leg.key]
+		if !ok {
+			return node, nil // path doesn't exist, nothing to do
+		}
+		v, err := patchJsonValue(child, legs[1:], op, val)
+		if err != nil {
+			return nil, err
+		}
+		obj[leg.key] = v
+		return obj, nil
+	}
+	_, exists := obj[leg.key]
+	switch op {
+	case JsonPatchReplace:
+		if exists {
+			obj[leg.key] = val
+		}
+	case JsonPatchInsert:
+		if !exists {
+			obj[leg.key] = val
+		}
+	case JsonPatchRemove:
+		delete(obj, leg.key)
+	}
+	return obj, nil
+}
+
+func decodeJson(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid json document, %w", err)
+	}
+	return v, nil
+}
+
+func encodeJson(v any) (string, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,185 @@
+package pump
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestApplyJsonPatch(t *testing.T) {
+	doc := `{"name":"apple","tags":["a","b"],"meta":{"a b":1}}`
+	tab := []struct {
+		op       JsonPatchOp
+		expected string
+	}{
+		{JsonPatchOp{Op: JsonPatchReplace, Path: "$.name", Value: []byte(`"banana"`)}, `{"meta":{"a b":1},"name":"banana","tags":["a","b"]}`},
+		{JsonPatchOp{Op: JsonPatchReplace, Path: "$.missing", Value: []byte(`1`)}, `{"meta":{"a b":1},"name":"apple","tags":["a","b"]}`},
+		{JsonPatchOp{Op: JsonPatchReplace, Path: `$.meta."a b"`, Value: []byte(`12.50`)}, `{"meta":{"a b":12.50},"name":"apple","tags":["a","b"]}`},
+		{JsonPatchOp{Op: JsonPatchInsert, Path: "$.tags[1]", Value: []byte(`"<c>"`)}, `{"meta":{"a b":1},"name":"apple","tags":["a","<c>","b"]}`},
+		{JsonPatchOp{Op: JsonPatchInsert, Path: "$.tags[9]", Value: []byte(`"c"`)}, `{"meta":{"a b":1},"name":"apple","tags":["a","b","c"]}`},
+		{JsonPatchOp{Op: JsonPatchInsert, Path: "$.name", Value: []byte(`"banana"`)}, `{"meta":{"a b":1},"name":"apple","tags":["a","b"]}`},
+		{JsonPatchOp{Op: JsonPatchInsert, Path: "$.price", Value: []byte(`null`)}, `{"meta":{"a b":1},"name":"apple","price":null,"tags":["a","b"]}`},
+		{JsonPatchOp{Op: JsonPatchRemove, Path: "$.tags[0]"}, `{"meta":{"a b":1},"name":"apple","tags":["b"]}`},
+		{JsonPatchOp{Op: JsonPatchRemove, Path: "$.meta"}, `{"name":"apple","tags":["a","b"]}`},
+		{JsonPatchOp{Op: JsonPatchReplace, Path: "$", Value: []byte(`[1]`)}, `[1]`},
+	}
+	for _, v := range tab {
+		actual, err := applyJsonPatch(doc, v.op)
+		if err != nil {
+			t.Fatalf("op: %+v, %v", v.op, err)
+		}
+		if actual != v.expected {
+			t.Fatalf("op: %+v, expected: %v, actual: %v", v.op, v.expected, actual)
+		}
+	}
+
+	for _, p := range []string{"$.tags[*]", "$**.name", "name", `$."a`} {
+		if _, err := applyJsonPatch(doc, JsonPatchOp{Op: JsonPatchRemove, Path: p}); err == nil {
+			t.Fatalf("path: %v, should fail", p)
+		}
+	}
+	if _, err := applyJsonPatch(doc, JsonPatchOp{Op: JsonPatchReplace, Path: "$.name[0]", Value: []byte(`1`)}); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestRebuildPartialJson(t *testing.T) {
+	rec := Record{
+		Before: []any{1, `{"a":1}`},
+		After:  []any{1, &replication.JsonDiff{Op: replication.JsonDiffOperationReplace, Path: "$.a", Value: "2"}},
+	}
+	rebuildPartialJson(&rec)
+	if rec.After[1] != `{"a":2}` || len(rec.AfterAbsent) > 0 || len(rec.AfterJsonPatch) > 0 {
+		t.Fatalf("%+v", rec)
+	}
+
+	// before image is absent, e.g., binlog_row_image=MINIMAL
+	rec = Record{
+		Before:       []any{1, nil},
+		BeforeAbsent: []int{1},
+		After:        []any{1, &replication.JsonDiff{Op: replication.JsonDiffOperationRemove, Path: "$.a"}},
+	}
+	rebuildPartialJson(&rec)
+	if rec.After[1] != nil || !rec.HasAfter(0) || rec.HasAfter(1) {
+		t.Fatalf("%+v", rec)
+	}
+	ops := rec.AfterJsonPatch[1]
+	if len(ops) != 1 || ops[0].Op != JsonPatchRemove || ops[0].Path != "$.a" || ops[0].Value != nil {
+		t.Fatalf("%+v", ops)
+	}
+
+	dce := DataChangeEvent{
+		Type:    TypeUpdate,
+		Columns: []RecordColumn{{Name: "id", DataType: "int", PrimaryKey: true}, {Name: "doc", DataType: "json"}},
+		Records: []Record{rec},
+	}
+	mapped, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := mapped[0].(StreamEvent).Columns["doc"]
+	if !col.AfterAbsent || col.AfterNull || len(col.JsonPatch) != 1 || !col.Changed() {
+		t.Fatalf("%+v", col)
+	}
+}
+
+func TestDecodeJsonDiffVectors(t *testing.T) {
+	raw := func(typ replication.EventType, body []byte) []byte {
+		size := replication.EventHeaderSize + len(body) + replication.BinlogChecksumLength
+		b := binary.LittleEndian.AppendUint32(nil, 0) // timestamp
+		b = append(b, byte(typ))
+		b = binary.LittleEndian.AppendUint32(b, 1) // server id
+		b = binary.LittleEndian.AppendUint32(b, uint32(size))
+		b = binary.LittleEndian.AppendUint32(b, 0) // log pos
+		b = binary.LittleEndian.AppendUint16(b, 0) // flags
+		b = append(b, body...)
+		return append(b, 0, 0, 0, 0) // checksum, not verified
+	}
+	jsonValue := func(v []byte) []byte {
+		return append(binary.LittleEndian.AppendUint32(nil, uint32(len(v))), v...)
+	}
+	diff := func(op replication.JsonDiffOperation, path string, v []byte) []byte {
+		b := append([]byte{byte(op), byte(len(path))}, path...)
+		if op != replication.JsonDiffOperationRemove {
+			b = append(append(b, byte(len(v))), v...)
+		}
+		return b
+	}
+	int16Json := func(v byte) []byte { return []byte{0x05, v, 0x00} }
+	doc := []byte{0x00, 0x02, 0x00, 0x14, 0x00, 0x12, 0x00, 0x01, 0x00, 0x13, 0x00, 0x01, 0x00,
+		0x05, 0x01, 0x00, 0x05, 0x02, 0x00, 'a', 'b'} // {"a":1,"b":2}
+	row := func(id byte, name string, json []byte) []byte {
+		b := append([]byte{0x00, id, 0x00, 0x00, 0x00, byte(len(name))}, name...) // null bitmap, id, name
+		return append(b, jsonValue(json)...)
+	}
+	partialRow := func(id byte, name string, diffs ...[]byte) []byte {
+		vec := []byte{}
+		for _, d := range diffs {
+			vec = append(vec, d...)
+		}
+		return append([]byte{0x01, 0x01}, row(id, name, vec)...) // binlog_row_value_options, partial bitmap
+	}
+
+	fde := binary.LittleEndian.AppendUint16(nil, 4)
+	fde = append(fde, make([]byte, 50)...)
+	copy(fde[2:], "8.0.40")
+	fde = append(fde, 0, 0, 0, 0, replication.EventHeaderSize)
+	postHeaderLen := make([]byte, replication.PARTIAL_UPDATE_ROWS_EVENT)
+	for i := range postHeaderLen {
+		postHeaderLen[i] = 10
+	}
+	postHeaderLen[replication.TABLE_MAP_EVENT-1] = 8
+	fde = append(append(fde, postHeaderLen...), replication.BINLOG_CHECKSUM_ALG_CRC32)
+
+	tme := []byte{0x01, 0, 0, 0, 0, 0, 0, 0, 5, 'm', 'y', '_', 'd', 'b', 0, 3, 'd', 'o', 'c', 0, 3,
+		mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_JSON, 3, 20, 0, 4, 0x06}
+
+	rows := []byte{0x01, 0, 0, 0, 0, 0, 0, 0, 2, 0, 3, 0x07, 0x07}
+	rows = append(rows, row(1, "apple", doc)...)
+	rows = append(rows, partialRow(1, "apple",
+		diff(replication.JsonDiffOperationReplace, "$.a", int16Json(10)),
+		diff(replication.JsonDiffOperationRemove, "$.b", nil),
+		diff(replication.JsonDiffOperationInsert, "$.c", int16Json(3)))...)
+	rows = append(rows, row(2, "peach", doc)...)
+	rows = append(rows, partialRow(2, "peach", diff(replication.JsonDiffOperationReplace, "$.b", int16Json(20)))...)
+
+	parser := replication.NewBinlogParser()
+	var ev *replication.BinlogEvent
+	for _, b := range [][]byte{raw(replication.FORMAT_DESCRIPTION_EVENT, fde), raw(replication.TABLE_MAP_EVENT, tme),
+		raw(replication.PARTIAL_UPDATE_ROWS_EVENT, rows)} {
+		var err error
+		if ev, err = parser.Parse(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	re := ev.Event.(*replication.RowsEvent)
+	if d, ok := re.Rows[1][2].(*replication.JsonDiff); !ok || d.Path != "$.a" {
+		t.Fatalf("only the first diff is decoded by go-mysql, %#v", re.Rows[1][2])
+	}
+	re.Table.ColumnName = [][]byte{[]byte("id"), []byte("name"), []byte("doc")}
+
+	s := newTestSource(t, flavorMysql)
+	var received []DataChangeEvent
+	id := OnEventReceived(func(c miso.Rail, dce DataChangeEvent, ctx *EventHandleContext) error {
+		received = append(received, dce)
+		return nil
+	})
+	defer RemoveEventHandler(id)
+
+	if err := s.handleEvent(miso.EmptyRail(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || len(received[0].Records) != 2 {
+		t.Fatalf("%+v", received)
+	}
+	recs := received[0].Records
+	if recs[0].After[1] != "apple" || recs[0].After[2] != `{"a":10,"c":3}` {
+		t.Fatalf("%+v", recs[0])
+	}
+	if recs[1].After[1] != "peach" || recs[1].After[2] != `{"a":1,"b":20}` {
+		t.Fatalf("%+v", recs[1])
+	}
+}
@@ -1,19 +1,71 @@
 package pump
 
-import "fmt"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
 
 type StreamEvent struct {
-	Timestamp uint32                       `json:"timestamp"` // Epoch time second
-	Schema    string                       `json:"schema"`    // Schema name
-	Table     string                       `json:"table"`     // Table name
-	Type      string                       `json:"type"`      // Event Type: INS-INSERT, UPD-UPDATE, DEL-DELETE
-	Columns   map[string]StreamEventColumn `json:"columns"`   // Map of column name and value changes
+	Source    string                       `json:"source"`        // Name of the source
+	Timestamp uint32                       `json:"timestamp"`     // Epoch time second
+	Schema    string                       `json:"schema"`        // Schema name
+	Table     string                       `json:"table"`         // Table name
+	Type      string                       `json:"type"`          // Event Type: INS-INSERT, UPD-UPDATE, DEL-DELETE, DDL-DDL, SNAP-SNAPSHOT
+	Columns   map[string]StreamEventColumn `json:"columns"`       // Map of column name and value changes
+	Key       map[string]string            `json:"key,omitempty"` // Primary key columns and values (after image, or before image for DEL)
+	DDL       *StreamEventDDL              `json:"ddl,omitempty"` // Schema change, only for DDL event
+	TxId      string                       `json:"txId"`          // Transaction id, GTID if available, otherwise the binlog position of the beginning of the transaction
+	TxSeq     int                          `json:"txSeq"`         // Sequence of the record within the transaction
+
+	EventId    string `json:"eventId"`        // Deterministic event id, it can be used as idempotency key
+	BinlogFile string `json:"binlogFile"`     // Binlog file name
+	BinlogPos  uint32 `json:"binlogPos"`      // Start position of the binlog event
+	ServerId   uint32 `json:"serverId"`       // Server id of the instance that wrote the binlog event
+	GTID       string `json:"gtid,omitempty"` // GTID of the transaction, if available
+	RowIndex   int    `json:"rowIndex"`       // Index of the row within the binlog event
+}
+
+// Events of a committed transaction, only published by transactional pipelines.
+type TxStreamEvent struct {
+	Source    string `json:"source"`    // Name of the source
+	TxId      string `json:"txId"`      // Transaction id
+	Timestamp uint32 `json:"timestamp"` // Epoch time second
+	Events    []any  `json:"events"`    // Events of the transaction in order
+}
+
+type StreamEventDDL struct {
+	Statement  string         `json:"statement"`  // DDL statement
+	OldColumns []RecordColumn `json:"oldColumns"` // Columns before the DDL
+	NewColumns []RecordColumn `json:"newColumns"` // Columns after the DDL
 }
 
 type StreamEventColumn struct {
 	DataType string `json:"dataType"`
 	Before   string `json:"before"`
 	After    string `json:"after"`
+
+	BeforeNull bool `json:"beforeNull,omitempty"` // Before value is NULL, Before is '<nil>' when it's NULL
+	AfterNull  bool `json:"afterNull,omitempty"`  // After value is NULL, After is '<nil>' when it's NULL
+
+	BeforeAbsent bool `json:"beforeAbsent,omitempty"` // column is not present in the before image (binlog_row_image=MINIMAL/NOBLOB)
+	AfterAbsent  bool `json:"afterAbsent,omitempty"`  // column is not present in the after image (binlog_row_image=MINIMAL/NOBLOB)
+
+	JsonPatch []JsonPatchOp `json:"jsonPatch,omitempty"` // partial JSON update that can't be applied to the before image, After is absent
+}
+
+// Whether the column is present in both images and the value is changed, NULL and empty string are different.
+func (c StreamEventColumn) Changed() bool {
+	if len(c.JsonPatch) > 0 {
+		return true
+	}
+	if c.BeforeAbsent || c.AfterAbsent {
+		return false
+	}
+	if c.BeforeNull || c.AfterNull {
+		return c.BeforeNull != c.AfterNull
+	}
+	return c.Before != c.After
 }
 
 type Mapper interface {
@@ -24,38 +76,116 @@ type streamEventMapper struct {
 }
 
 func (m streamEventMapper) MapEvent(dce DataChangeEvent) ([]any, error) {
+	if dce.Type == TypeDDL {
+		return []any{StreamEvent{
+			Source:    dce.Source,
+			Timestamp: dce.Timestamp,
+			Schema:    dce.Schema,
+			Table:     dce.Table,
+			Type:      dce.Type,
+			Columns:   map[string]StreamEventColumn{},
+			DDL: &StreamEventDDL{
+				Statement:  dce.Statement,
+				OldColumns: dce.OldColumns,
+				NewColumns: dce.Columns,
+			},
+			TxId:  dce.TxId,
+			TxSeq: dce.TxSeq,
+
+			EventId:    eventId(dce, 0),
+			BinlogFile: dce.BinlogFile,
+			BinlogPos:  dce.BinlogPos,
+			ServerId:   dce.ServerId,
+			GTID:       dce.GTID,
+		}}, nil
+	}
+
 	mapped := []any{}
-	for _, rec := range dce.Records {
+	for i, rec := range dce.Records {
 		columns := map[string]StreamEventColumn{}
+		var key map[string]string
 		for j, col := range dce.Columns {
 			var before string
 			var after string
 
-			if j < len(rec.Before) {
-				before = fmt.Sprintf("%v", rec.Before[j])
+			hasBefore := rec.HasBefore(j)
+			hasAfter := rec.HasAfter(j)
+			beforeAbsent := len(rec.Before) > 0 && !hasBefore
+			afterAbsent := len(rec.After) > 0 && !hasAfter
+			if hasBefore {
+				before = fmt.Sprintf("%v", rec.Before[j]) // NULL is formatted as '<nil>' for backward compatibility
 			}
-			if j < len(rec.After) {
+			if hasAfter {
 				after = fmt.Sprintf("%v", rec.After[j])
 			}
 			columns[col.Name] = StreamEventColumn{
 				DataType: col.DataType,
 				Before:   before,
 				After:    after,
+
+				// only set when the image exists, e.g., BeforeNull is always false for INS
+				BeforeNull: hasBefore && rec.Before[j] == nil,
+				AfterNull:  hasAfter && rec.After[j] == nil,
+
+				BeforeAbsent: beforeAbsent,
+				AfterAbsent:  afterAbsent,
+
+				JsonPatch: rec.AfterJsonPatch[j],
+			}
+			if col.PrimaryKey {
+				if key == nil {
+					key = map[string]string{}
+				}
+				if hasAfter {
+					key[col.Name] = after
+				} else {
+					key[col.Name] = before
+				}
 			}
 		}
 
 		mapped = append(mapped, StreamEvent{
+			Source:    dce.Source,
 			Timestamp: dce.Timestamp,
 			Schema:    dce.Schema,
 			Table:     dce.Table,
 			Type:      dce.Type,
 			Columns:   columns,
+			Key:       key,
+			TxId:      dce.TxId,
+			TxSeq:     dce.TxSeq + i,
+
+			EventId:    eventId(dce, i),
+			BinlogFile: dce.BinlogFile,
+			BinlogPos:  dce.BinlogPos,
+			ServerId:   dce.ServerId,
+			GTID:       dce.GTID,
+			RowIndex:   i,
 		})
 	}
 
 	return mapped, nil
 }
 
-func NewMapper() Mapper {
+// Build deterministic event id using the binlog coordinates, the id stays the same when the binlog event is redelivered,
+// e.g., after restart or the binlog position is rewound.
+func eventId(dce DataChangeEvent, rowIndex int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%d|%s|%d|%s.%s|%d", dce.Source, dce.ServerId, dce.BinlogFile, dce.BinlogPos, dce.Schema, dce.Table, rowIndex)
+	if dce.Type == TypeSnapshot {
+		// rows of the snapshot share the recorded binlog position, TxSeq is unique within the snapshot
+		fmt.Fprintf(h, "|%d", dce.TxSeq)
+	}
+	if dce.PayloadIndex > 0 {
+		// events in the same compressed transaction payload share the binlog position of the payload
+		fmt.Fprintf(h, "|%d", dce.PayloadIndex)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func NewMapper(format string) Mapper {
+	if format == FormatV2 {
+		return typedStreamEventMapper{}
+	}
 	return streamEventMapper{}
 }
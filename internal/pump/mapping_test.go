@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"testing"
 	"time"
+
+	"github.com/curtisnewbie/miso/miso"
 )
 
 func TestMarshalStreamEventStruct(t *testing.T) {
@@ -31,3 +33,288 @@ func TestMarshalStreamEventStruct(t *testing.T) {
 	}
 	t.Logf("\n%v", string(b))
 }
+
+func TestTypedStreamEventMapper(t *testing.T) {
+	dce := DataChangeEvent{
+		Timestamp: 1688199982,
+		Schema:    "my_db",
+		Table:     "my_table",
+		Type:      TypeUpdate,
+		Columns: []RecordColumn{
+			{Name: "id", DataType: "bigint"},
+			{Name: "price", DataType: "decimal"},
+			{Name: "name", DataType: "varchar"},
+			{Name: "data", DataType: "blob"},
+			{Name: "attr", DataType: "json"},
+			{Name: "ctime", DataType: "datetime"},
+			{Name: "remark", DataType: "text"},
+		},
+		Records: []Record{
+			{
+				Before: []any{int64(1), "12.30", "banana", []byte{0x01, 0x02}, `{"a":1}`, "2023-07-01 12:00:01", nil},
+				After:  []any{int64(1), "12.50", "apple", []byte{0x01, 0x02}, `{"a":2}`, "2023-07-01 12:00:01.5", []byte("hi")},
+			},
+		},
+	}
+	mapped, err := typedStreamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapped) != 1 {
+		t.Fatalf("len(mapped) should be 1, but got %v", len(mapped))
+	}
+	b, err := json.Marshal(mapped[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%s", b)
+
+	var evt struct {
+		Columns map[string]struct {
+			Before json.RawMessage
+			After  json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(b, &evt); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][2]string{
+		"id":     {`1`, `1`},
+		"price":  {`12.30`, `12.50`},
+		"name":   {`"banana"`, `"apple"`},
+		"data":   {`"AQI="`, `"AQI="`},
+		"attr":   {`{"a":1}`, `{"a":2}`},
+		"ctime":  {`"2023-07-01T12:00:01"`, `"2023-07-01T12:00:01.5"`},
+		"remark": {`null`, `"hi"`},
+	}
+	for name, ex := range expected {
+		c, ok := evt.Columns[name]
+		if !ok {
+			t.Fatalf("column %v not found", name)
+		}
+		if string(c.Before) != ex[0] || string(c.After) != ex[1] {
+			t.Fatalf("column %v, expected %v, but got [%s %s]", name, ex, c.Before, c.After)
+		}
+	}
+}
+
+func TestStreamEventMapperKey(t *testing.T) {
+	dce := DataChangeEvent{
+		Schema: "my_db",
+		Table:  "my_table",
+		Type:   TypeDelete,
+		Columns: []RecordColumn{
+			{Name: "tenant", DataType: "varchar", PrimaryKey: true},
+			{Name: "id", DataType: "bigint", PrimaryKey: true},
+			{Name: "name", DataType: "varchar"},
+		},
+		Records: []Record{
+			{Before: []any{"t1", int64(1), "apple"}},
+		},
+	}
+	mapped, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := mapped[0].(StreamEvent).Key
+	if len(key) != 2 || key["tenant"] != "t1" || key["id"] != "1" {
+		t.Fatalf("incorrect key: %+v", key)
+	}
+
+	dce.Type = TypeInsert
+	dce.Records = []Record{{After: []any{"t2", int64(2), "banana"}}}
+	mapped, err = typedStreamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyV2 := mapped[0].(StreamEventV2).Key
+	if len(keyV2) != 2 || keyV2["tenant"] != "t2" || keyV2["id"] != int64(2) {
+		t.Fatalf("incorrect key: %+v", keyV2)
+	}
+
+	dce.Columns[0].PrimaryKey = false
+	dce.Columns[1].PrimaryKey = false
+	mapped, err = streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped[0].(StreamEvent).Key != nil {
+		t.Fatal("key should be absent")
+	}
+}
+
+func TestEventId(t *testing.T) {
+	dce := DataChangeEvent{
+		Source:     "default",
+		Schema:     "my_db",
+		Table:      "my_table",
+		Type:       TypeInsert,
+		Columns:    []RecordColumn{{Name: "id", DataType: "int"}},
+		Records:    []Record{{After: []any{1}}, {After: []any{2}}},
+		BinlogFile: "binlog.000001",
+		BinlogPos:  53318,
+		ServerId:   1,
+		GTID:       "3e11fa47-71ca-11e1-9e33-c80aa9429562:6",
+	}
+	mapped, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := mapped[0].(StreamEvent)
+	second := mapped[1].(StreamEvent)
+	if first.EventId == "" || first.EventId == second.EventId {
+		t.Fatalf("event id should be unique, %v, %v", first.EventId, second.EventId)
+	}
+	if second.RowIndex != 1 || second.BinlogFile != dce.BinlogFile || second.BinlogPos != dce.BinlogPos || second.ServerId != 1 || second.GTID != dce.GTID {
+		t.Fatalf("%+v", second)
+	}
+
+	// redelivered
+	again, err := typedStreamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].(StreamEventV2).EventId != first.EventId {
+		t.Fatal("event id should be deterministic")
+	}
+
+	dce.BinlogPos = 53400
+	moved, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved[0].(StreamEvent).EventId == first.EventId {
+		t.Fatal("event id should be different for different binlog positions")
+	}
+}
+
+func TestMinimalRowImage(t *testing.T) {
+	// binlog_row_image=MINIMAL, before image only contains the PK, after image only contains the updated columns
+	dce := DataChangeEvent{
+		Schema: "my_db",
+		Table:  "my_table",
+		Type:   TypeUpdate,
+		Columns: []RecordColumn{
+			{Name: "id", DataType: "bigint", PrimaryKey: true},
+			{Name: "name", DataType: "varchar"},
+			{Name: "status", DataType: "varchar"},
+		},
+		Records: []Record{
+			{
+				Before:       []any{int64(1), nil, nil},
+				BeforeAbsent: []int{1, 2},
+				After:        []any{nil, "apple", nil},
+				AfterAbsent:  []int{0, 2},
+			},
+		},
+	}
+	mapped, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := mapped[0].(StreamEvent)
+	if ev.Key["id"] != "1" {
+		t.Fatalf("incorrect key: %+v", ev.Key)
+	}
+	if c := ev.Columns["id"]; c.BeforeAbsent || !c.AfterAbsent || c.Before != "1" || c.After != "" {
+		t.Fatalf("%+v", c)
+	}
+	if c := ev.Columns["name"]; !c.BeforeAbsent || c.AfterAbsent || c.Before != "" || c.After != "apple" {
+		t.Fatalf("%+v", c)
+	}
+
+	rail := miso.EmptyRail()
+	if (columnFilter{ColumnsChanged: []string{"name", "status"}}).Include(rail, ev) {
+		t.Fatal("columns absent from the before image should not be treated as changed")
+	}
+
+	mapped, err = typedStreamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evV2 := mapped[0].(StreamEventV2)
+	if c := evV2.Columns["status"]; !c.BeforeAbsent || !c.AfterAbsent || c.Before != nil || c.After != nil {
+		t.Fatalf("%+v", c)
+	}
+	if (columnFilter{ColumnsChanged: []string{"status"}}).Include(rail, evV2) {
+		t.Fatal("columns absent from both images should not be treated as changed")
+	}
+
+	// FULL image
+	dce.Records[0] = Record{Before: []any{int64(1), "banana", "ok"}, After: []any{int64(1), "apple", "ok"}}
+	mapped, err = streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = mapped[0].(StreamEvent)
+	if c := ev.Columns["name"]; c.BeforeAbsent || c.AfterAbsent {
+		t.Fatalf("%+v", c)
+	}
+	if !(columnFilter{ColumnsChanged: []string{"name"}}).Include(rail, ev) {
+		t.Fatal("name is changed")
+	}
+}
+
+func TestStreamEventColumnNull(t *testing.T) {
+	dce := DataChangeEvent{
+		Schema: "my_db",
+		Table:  "my_table",
+		Type:   TypeUpdate,
+		Columns: []RecordColumn{
+			{Name: "id", DataType: "bigint", PrimaryKey: true},
+			{Name: "name", DataType: "varchar"},
+		},
+		Records: []Record{
+			{Before: []any{int64(1), nil}, After: []any{int64(1), ""}},
+		},
+	}
+	mapped, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := mapped[0].(StreamEvent)
+	if c := ev.Columns["name"]; !c.BeforeNull || c.AfterNull || c.Before != "<nil>" || c.After != "" {
+		t.Fatalf("%+v", c)
+	}
+
+	rail := miso.EmptyRail()
+	if !(columnFilter{ColumnsChanged: []string{"name"}}).Include(rail, ev) {
+		t.Fatal("change from NULL to empty string should be included")
+	}
+	if (columnFilter{ColumnsChanged: []string{"id"}}).Include(rail, ev) {
+		t.Fatal("id is not changed")
+	}
+
+	mapped, err = typedStreamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(columnFilter{ColumnsChanged: []string{"name"}}).Include(rail, mapped[0].(StreamEventV2)) {
+		t.Fatal("change from NULL to empty string should be included")
+	}
+
+	dce.Type = TypeInsert
+	dce.Records = []Record{{After: []any{int64(2), nil}}}
+	mapped, err = streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = mapped[0].(StreamEvent)
+	if c := ev.Columns["id"]; c.BeforeNull || c.AfterNull || c.After != "2" {
+		t.Fatalf("%+v", c)
+	}
+	if c := ev.Columns["name"]; c.BeforeNull || !c.AfterNull || c.Before != "" || c.After != "<nil>" {
+		t.Fatalf("%+v", c)
+	}
+
+	dce.Type = TypeDelete
+	dce.Records = []Record{{Before: []any{int64(2), nil}}}
+	mapped, err = streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = mapped[0].(StreamEvent)
+	if c := ev.Columns["name"]; !c.BeforeNull || c.AfterNull || c.Before != "<nil>" || c.After != "" {
+		t.Fatalf("%+v", c)
+	}
+}
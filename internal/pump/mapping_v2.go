@@ -0,0 +1,232 @@
+package pump
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FormatV1 = "v1" // column values are formatted as strings, see StreamEvent
+	FormatV2 = "v2" // column values are typed json values, see StreamEventV2
+)
+
+// Event with typed column values.
+//
+// Numbers are json numbers (decimals keep the original formatting), strings are json strings,
+// binary values are base64 encoded, json columns are embedded as json, NULL is json null,
+// and date/time values are formatted in ISO 8601.
+type StreamEventV2 struct {
+	Version   int                            `json:"version"`       // Event format version, always 2
+	Source    string                         `json:"source"`        // Name of the source
+	Timestamp uint32                         `json:"timestamp"`     // Epoch time second
+	Schema    string                         `json:"schema"`        // Schema name
+	Table     string                         `json:"table"`         // Table name
+	Type      string                         `json:"type"`          // Event Type: INS-INSERT, UPD-UPDATE, DEL-DELETE, DDL-DDL, SNAP-SNAPSHOT
+	Columns   map[string]StreamEventColumnV2 `json:"columns"`       // Map of column name and value changes
+	Key       map[string]any                 `json:"key,omitempty"` // Primary key columns and values (after image, or before image for DEL)
+	DDL       *StreamEventDDL                `json:"ddl,omitempty"` // Schema change, only for DDL event
+	TxId      string                         `json:"txId"`          // Transaction id
+	TxSeq     int                            `json:"txSeq"`         // Sequence of the record within the transaction
+
+	EventId    string `json:"eventId"`        // Deterministic event id, it can be used as idempotency key
+	BinlogFile string `json:"binlogFile"`     // Binlog file name
+	BinlogPos  uint32 `json:"binlogPos"`      // Start position of the binlog event
+	ServerId   uint32 `json:"serverId"`       // Server id of the instance that wrote the binlog event
+	GTID       string `json:"gtid,omitempty"` // GTID of the transaction, if available
+	RowIndex   int    `json:"rowIndex"`       // Index of the row within the binlog event
+}
+
+type StreamEventColumnV2 struct {
+	DataType string `json:"dataType"`
+	Before   any    `json:"before"`
+	After    any    `json:"after"`
+
+	BeforeAbsent bool `json:"beforeAbsent,omitempty"` // column is not present in the before image (binlog_row_image=MINIMAL/NOBLOB)
+	AfterAbsent  bool `json:"afterAbsent,omitempty"`  // column is not present in the after image (binlog_row_image=MINIMAL/NOBLOB)
+
+	JsonPatch []JsonPatchOp `json:"jsonPatch,omitempty"` // partial JSON update that can't be applied to the before image, After is absent
+}
+
+// Whether the column is present in both images and the value is changed.
+func (c StreamEventColumnV2) Changed() bool {
+	if len(c.JsonPatch) > 0 {
+		return true
+	}
+	if c.BeforeAbsent || c.AfterAbsent {
+		return false
+	}
+	return !reflect.DeepEqual(c.Before, c.After)
+}
+
+type typedStreamEventMapper struct {
+}
+
+func (m typedStreamEventMapper) MapEvent(dce DataChangeEvent) ([]any, error) {
+	if dce.Type == TypeDDL {
+		return []any{StreamEventV2{
+			Source:    dce.Source,
+			Version:   2,
+			Timestamp: dce.Timestamp,
+			Schema:    dce.Schema,
+			Table:     dce.Table,
+			Type:      dce.Type,
+			Columns:   map[string]StreamEventColumnV2{},
+			DDL: &StreamEventDDL{
+				Statement:  dce.Statement,
+				OldColumns: dce.OldColumns,
+				NewColumns: dce.Columns,
+			},
+			TxId:  dce.TxId,
+			TxSeq: dce.TxSeq,
+
+			EventId:    eventId(dce, 0),
+			BinlogFile: dce.BinlogFile,
+			BinlogPos:  dce.BinlogPos,
+			ServerId:   dce.ServerId,
+			GTID:       dce.GTID,
+		}}, nil
+	}
+
+	mapped := []any{}
+	for i, rec := range dce.Records {
+		columns := map[string]StreamEventColumnV2{}
+		var key map[string]any
+		for j, col := range dce.Columns {
+			var before any
+			var after any
+
+			hasBefore := rec.HasBefore(j)
+			hasAfter := rec.HasAfter(j)
+			if hasBefore {
+				before = typedValue(col, rec.Before[j])
+			}
+			if hasAfter {
+				after = typedValue(col, rec.After[j])
+			}
+			columns[col.Name] = StreamEventColumnV2{
+				DataType: col.DataType,
+				Before:   before,
+				After:    after,
+
+				BeforeAbsent: len(rec.Before) > 0 && !hasBefore,
+				AfterAbsent:  len(rec.After) > 0 && !hasAfter,
+
+				JsonPatch: rec.AfterJsonPatch[j],
+			}
+			if col.PrimaryKey {
+				if key == nil {
+					key = map[string]any{}
+				}
+				if hasAfter {
+					key[col.Name] = after
+				} else {
+					key[col.Name] = before
+				}
+			}
+		}
+
+		mapped = append(mapped, StreamEventV2{
+			Source:    dce.Source,
+			Version:   2,
+			Timestamp: dce.Timestamp,
+			Schema:    dce.Schema,
+			Table:     dce.Table,
+			Type:      dce.Type,
+			Columns:   columns,
+			Key:       key,
+			TxId:      dce.TxId,
+			TxSeq:     dce.TxSeq + i,
+
+			EventId:    eventId(dce, i),
+			BinlogFile: dce.BinlogFile,
+			BinlogPos:  dce.BinlogPos,
+			ServerId:   dce.ServerId,
+			GTID:       dce.GTID,
+			RowIndex:   i,
+		})
+	}
+
+	return mapped, nil
+}
+
+// Convert column value decoded by go-mysql to json friendly value without losing information.
+func typedValue(col RecordColumn, v any) any {
+	if v == nil {
+		return nil
+	}
+
+	dataType := strings.ToLower(col.DataType)
+	switch vt := v.(type) {
+	case []byte:
+		return typedBytes(dataType, vt)
+	case string:
+		return typedString(dataType, vt)
+	case decimal.Decimal:
+		return json.Number(vt.String())
+	case time.Time:
+		if dataType == "datetime" {
+			return vt.Format(isoDateTimeFormat)
+		}
+		return vt.Format(time.RFC3339Nano)
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint, float32, float64, bool:
+		return vt
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+const (
+	isoDateTimeFormat = "2006-01-02T15:04:05.999999"
+)
+
+func typedBytes(dataType string, v []byte) any {
+	switch dataType {
+	case "json":
+		if len(v) < 1 {
+			return nil // empty document is interpreted as json null
+		}
+		if json.Valid(v) {
+			return json.RawMessage(v)
+		}
+		return string(v)
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		return string(v)
+	}
+	return base64.StdEncoding.EncodeToString(v)
+}
+
+func typedString(dataType string, v string) any {
+	switch dataType {
+	case "decimal":
+		return json.Number(v)
+	case "json":
+		if v == "" {
+			return nil // empty document is interpreted as json null
+		}
+		if json.Valid([]byte(v)) {
+			return json.RawMessage(v)
+		}
+		return v
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "geometry":
+		return base64.StdEncoding.EncodeToString([]byte(v))
+	case "datetime":
+		if t, err := time.ParseInLocation(mysqlDateTimeFormat, v, time.UTC); err == nil {
+			return t.Format(isoDateTimeFormat)
+		}
+	case "timestamp":
+		// go-mysql formats timestamp in local timezone
+		if t, err := time.ParseInLocation(mysqlDateTimeFormat, v, time.Local); err == nil {
+			return t.Format(time.RFC3339Nano)
+		}
+	}
+	return v
+}
+
+const (
+	mysqlDateTimeFormat = "2006-01-02 15:04:05.999999"
+)
@@ -1,11 +1,38 @@
 package pump
 
-import "github.com/curtisnewbie/miso/miso"
+import (
+	"fmt"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 var (
 	binlogEventHisto = miso.NewPromHisto("event_pump_binlog_event")
+	binlogLagGauge   = newPromGaugeVec("event_pump_binlog_lag_seconds", []string{"source"})
+
+	streamRestartCounter        = newPromCounterVec("event_pump_stream_restart", []string{"source"})
+	streamRestartFailureCounter = newPromCounterVec("event_pump_stream_restart_failure", []string{"source"})
+	purgedPosCounter            = newPromCounterVec("event_pump_purged_pos", []string{"source", "policy"})
+	snapshotRowsCounter         = newPromCounterVec("event_pump_snapshot_rows", []string{"source"})
 )
 
 func NewBinlogEventTimer() *miso.HistTimer {
 	return miso.NewHistTimer(binlogEventHisto)
 }
+
+func newPromGaugeVec(name string, labels []string) *prometheus.GaugeVec {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name}, labels)
+	if e := prometheus.DefaultRegisterer.Register(vec); e != nil {
+		panic(fmt.Errorf("failed to register GaugeVec %v, %w", name, e))
+	}
+	return vec
+}
+
+func newPromCounterVec(name string, labels []string) *prometheus.CounterVec {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name}, labels)
+	if e := prometheus.DefaultRegisterer.Register(vec); e != nil {
+		panic(fmt.Errorf("failed to register CounterVec %v, %w", name, e))
+	}
+	return vec
+}
@@ -0,0 +1,30 @@
+package pump
+
+import (
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Handle the events in the compressed transaction payload (binlog_transaction_compression=ON, MySQL >= 8.0.20).
+//
+// The payload is already decompressed by the parser, the inner events are handled the same way as the other events.
+// Inner events don't have their own positions in the binlog file, they take the position of the payload event,
+// so that the transaction id and binlog coordinates point to the payload, and the pos is only moved to the end of the payload.
+func (s *Source) handlePayloadEvent(rail miso.Rail, ev *replication.BinlogEvent, pe *replication.TransactionPayloadEvent) error {
+	defer func() { s.payloadIdx = 0 }()
+
+	rail.Debugf("Handling transaction payload of %v events, compressed size: %v, uncompressed size: %v",
+		len(pe.Events), pe.Size, pe.UncompressedSize)
+
+	for i, inner := range pe.Events {
+		h := *inner.Header
+		h.LogPos = ev.Header.LogPos
+		h.EventSize = ev.Header.EventSize
+
+		s.payloadIdx = i + 1
+		if err := s.handleEvent(rail, &replication.BinlogEvent{RawData: inner.RawData, Header: &h, Event: inner.Event}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
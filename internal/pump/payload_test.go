@@ -0,0 +1,79 @@
+package pump
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+)
+
+func TestHandlePayloadEvent(t *testing.T) {
+	sid := uuid.MustParse("3e11fa47-71ca-11e1-9e33-c80aa9429562")
+	gset, err := mysql.ParseGTIDSet(flavorMysql, sid.String()+":1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSource(t, flavorMysql)
+	s.resetGTIDSet(gset)
+	s.nextPos = BinlogPos{Position: mysql.Position{Name: "binlog.000001", Pos: 4}}
+
+	var received []DataChangeEvent
+	id := OnEventReceived(func(c miso.Rail, dce DataChangeEvent, ctx *EventHandleContext) error {
+		received = append(received, dce)
+		return nil
+	})
+	defer RemoveEventHandler(id)
+
+	ev := func(typ replication.EventType, e replication.Event, logPos uint32, size uint32) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: typ, LogPos: logPos, EventSize: size}, Event: e}
+	}
+	tme := &replication.TableMapEvent{
+		Schema:      []byte("my_db"),
+		Table:       []byte("my_table"),
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONGLONG},
+		ColumnMeta:  []uint16{0},
+		ColumnName:  [][]byte{[]byte("id")},
+		PrimaryKey:  []uint64{0},
+	}
+	rows := func() *replication.BinlogEvent {
+		return ev(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{Table: tme, Rows: [][]any{{int64(1)}}}, 0, 0)
+	}
+	payload := &replication.TransactionPayloadEvent{
+		Events: []*replication.BinlogEvent{
+			ev(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("BEGIN")}, 0, 0),
+			ev(replication.TABLE_MAP_EVENT, tme, 0, 0),
+			rows(),
+			rows(),
+			ev(replication.XID_EVENT, &replication.XIDEvent{}, 0, 0),
+		},
+	}
+
+	rail := miso.EmptyRail()
+	if err := s.handleEvent(rail, ev(replication.GTID_EVENT, &replication.GTIDEvent{SID: sid[:], GNO: 6}, 200, 79)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleEvent(rail, ev(replication.TRANSACTION_PAYLOAD_EVENT, payload, 500, 300)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 events, received: %v", len(received))
+	}
+	for i, dce := range received {
+		if dce.BinlogPos != 200 || dce.PayloadIndex != i+3 || dce.TxId != sid.String()+":6" || dce.TxSeq != i {
+			t.Fatalf("%+v", dce)
+		}
+	}
+	if eventId(received[0], 0) == eventId(received[1], 0) {
+		t.Fatal("event id should be unique within the payload")
+	}
+	if s.payloadIdx != 0 || s.isInTx() {
+		t.Fatalf("payloadIdx: %v, currTx: %+v", s.payloadIdx, s.currTx)
+	}
+	if s.nextPos.Name != "binlog.000001" || s.nextPos.Pos != 500 || s.nextPos.GTID != sid.String()+":1-6" {
+		t.Fatalf("%+v", s.nextPos)
+	}
+}
@@ -0,0 +1,108 @@
+package pump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+const (
+	PropSyncPurgedPosPolicy = "sync.purged-pos-policy"
+
+	PurgedPosPolicyFail     = "fail"     // fail the startup
+	PurgedPosPolicyEarliest = "earliest" // jump to the earliest binlog position
+	PurgedPosPolicyLatest   = "latest"   // jump to the latest binlog position
+	PurgedPosPolicySnapshot = "snapshot" // take snapshot of the tables, and resume from the latest binlog position
+)
+
+func init() {
+	miso.SetDefProp(PropSyncPurgedPosPolicy, PurgedPosPolicyFail)
+}
+
+func validPurgedPosPolicy(p string) bool {
+	switch p {
+	case PurgedPosPolicyFail, PurgedPosPolicyEarliest, PurgedPosPolicyLatest, PurgedPosPolicySnapshot:
+		return true
+	}
+	return false
+}
+
+// Check whether the stored binlog position is already purged on master.
+//
+// In GTID mode (MySQL), the position is purged if the executed GTID set doesn't contain the purged GTID set (gtid_purged),
+// otherwise, the position is purged if the binlog file is not listed in SHOW BINARY LOGS.
+func (s *Source) isPosPurged(rail miso.Rail, pos BinlogPos) (bool, error) {
+	if s.isGTIDMode() && s.flavor() == flavorMysql && pos.GTID != "" {
+		purged, err := s.fetchPurgedGTIDSet(rail)
+		if err != nil {
+			return false, err
+		}
+		return gtidSetPurged(s.flavor(), pos.GTID, purged)
+	}
+	if pos.Name == "" {
+		return false, nil
+	}
+	logs, err := s.FetchBinaryLogs(rail)
+	if err != nil {
+		return false, err
+	}
+	return binlogFilePurged(pos.Name, logs), nil
+}
+
+// Binlog file is purged if it's not listed in SHOW BINARY LOGS.
+func binlogFilePurged(file string, logs []BinaryLog) bool {
+	if len(logs) < 1 {
+		return false
+	}
+	for _, l := range logs {
+		if l.LogName == file {
+			return false
+		}
+	}
+	return true
+}
+
+// GTID set is purged if it doesn't contain all the purged GTIDs.
+func gtidSetPurged(flavor string, executed string, purged string) (bool, error) {
+	if strings.TrimSpace(purged) == "" {
+		return false, nil
+	}
+	e, err := mysql.ParseGTIDSet(flavor, executed)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse GTID set: '%v', %w", executed, err)
+	}
+	p, err := mysql.ParseGTIDSet(flavor, purged)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse purged GTID set: '%v', %w", purged, err)
+	}
+	return !e.Contain(p), nil
+}
+
+// Handle purged binlog position according to the purged-pos-policy.
+func (s *Source) handlePurgedPos(rail miso.Rail, pos BinlogPos) (BinlogPos, error) {
+	policy := s.conf.PurgedPosPolicy
+	purgedPosCounter.WithLabelValues(s.Name, policy).Inc()
+	rail.Errorf("Binlog position of source '%v' is already purged on master, position: %+v, purged-pos-policy: '%v'", s.Name, pos, policy)
+
+	var np BinlogPos
+	var err error
+	switch policy {
+	case PurgedPosPolicyEarliest:
+		np, err = s.initialPos(rail, startFrom{Raw: StartFromEarliest, Earliest: true})
+	case PurgedPosPolicyLatest:
+		np, err = s.initialPos(rail, startFrom{Raw: StartFromLatest})
+	case PurgedPosPolicySnapshot:
+		np, err = s.takeSnapshot(rail)
+	default:
+		return pos, fmt.Errorf("binlog position of source '%v' is already purged on master, position: %+v,"+
+			" please change the position manually, or change purged-pos-policy to '%v', '%v' or '%v'",
+			s.Name, pos, PurgedPosPolicyEarliest, PurgedPosPolicyLatest, PurgedPosPolicySnapshot)
+	}
+	if err != nil {
+		return pos, err
+	}
+	rail.Warnf("Binlog position of source '%v' jumped from %+v to %+v (%v), events in between are skipped", s.Name, pos, np, policy)
+	return np, nil
+}
@@ -0,0 +1,54 @@
+package pump
+
+import "testing"
+
+func TestBinlogFilePurged(t *testing.T) {
+	logs := []BinaryLog{{LogName: "binlog.000003"}, {LogName: "binlog.000004"}}
+	if !binlogFilePurged("binlog.000002", logs) {
+		t.Fatal("binlog.000002 should be purged")
+	}
+	if binlogFilePurged("binlog.000003", logs) {
+		t.Fatal("binlog.000003 should not be purged")
+	}
+	if binlogFilePurged("binlog.000003", nil) {
+		t.Fatal("binary logs are unknown")
+	}
+}
+
+func TestGTIDSetPurged(t *testing.T) {
+	uuid := "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	tab := []struct {
+		executed string
+		purged   string
+		expected bool
+	}{
+		{uuid + ":1-100", "", false},
+		{uuid + ":1-100", uuid + ":1-50", false},
+		{uuid + ":1-100", uuid + ":1-150", true},
+		{uuid + ":1-100", "4e11fa47-71ca-11e1-9e33-c80aa9429562:1-3", true},
+	}
+	for _, v := range tab {
+		purged, err := gtidSetPurged(flavorMysql, v.executed, v.purged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged != v.expected {
+			t.Fatalf("executed: %v, purged: %v, expected: %v", v.executed, v.purged, v.expected)
+		}
+	}
+}
+
+func TestPurgedPosPolicy(t *testing.T) {
+	conf := SourceConfig{Name: "test", Flavor: flavorMysql, PurgedPosPolicy: "skip"}
+	if _, err := NewSource(conf); err == nil {
+		t.Fatal("purged-pos-policy should be invalid")
+	}
+	conf.PurgedPosPolicy = " Latest "
+	s, err := NewSource(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.conf.PurgedPosPolicy != PurgedPosPolicyLatest {
+		t.Fatal(s.conf.PurgedPosPolicy)
+	}
+}
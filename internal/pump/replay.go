@@ -0,0 +1,196 @@
+package pump
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/spf13/cast"
+)
+
+const (
+	PropReplayEnabled     = "replay.enabled"
+	PropReplayFiles       = "replay.files"
+	PropReplaySource      = "replay.source"
+	PropReplayStartPos    = "replay.start-pos"
+	PropReplayStopPos     = "replay.stop-pos"
+	PropReplayPipelines   = "replay.pipelines"
+	PropReplayFetchSchema = "replay.fetch-schema"
+)
+
+var (
+	errReplayStopped = errors.New("replay stopped")
+)
+
+func init() {
+	miso.SetDefProp(PropReplayEnabled, false)
+	miso.SetDefProp(PropReplayFetchSchema, false)
+}
+
+// Replay of local binlog files, e.g., binlog files copied from a backup.
+type ReplayConfig struct {
+	Files       []string       // binlog files, replayed in order
+	StartPos    mysql.Position // optional, events before the position are skipped
+	StopPos     mysql.Position // optional, replay stops at the first event at or after the position
+	Pipelines   []string       // optional, streams of the pipelines to be replayed, all pipelines are replayed if empty
+	FetchSchema bool           // whether information_schema on master instance can be used when binlog metadata and schema history are missing
+}
+
+func isReplayMode() bool {
+	return miso.GetPropBool(PropReplayEnabled)
+}
+
+func LoadReplayConfig() (ReplayConfig, error) {
+	c := ReplayConfig{
+		Files:       miso.GetPropStrSlice(PropReplayFiles),
+		Pipelines:   miso.GetPropStrSlice(PropReplayPipelines),
+		FetchSchema: miso.GetPropBool(PropReplayFetchSchema),
+	}
+	if len(c.Files) < 1 {
+		return c, fmt.Errorf("replay mode is enabled, but '%v' is empty", PropReplayFiles)
+	}
+	var err error
+	if v := miso.GetPropStrTrimmed(PropReplayStartPos); v != "" {
+		if c.StartPos, err = parseFilePos(v); err != nil {
+			return c, fmt.Errorf("invalid '%v', %w", PropReplayStartPos, err)
+		}
+	}
+	if v := miso.GetPropStrTrimmed(PropReplayStopPos); v != "" {
+		if c.StopPos, err = parseFilePos(v); err != nil {
+			return c, fmt.Errorf("invalid '%v', %w", PropReplayStopPos, err)
+		}
+	}
+	return c, nil
+}
+
+// Parse position in format 'file:pos', e.g., 'binlog.000001:4'.
+func parseFilePos(v string) (mysql.Position, error) {
+	i := strings.LastIndex(v, ":")
+	if i < 1 {
+		return mysql.Position{}, fmt.Errorf("position should be in format 'file:pos', but got '%v'", v)
+	}
+	pos, err := cast.ToUint32E(v[i+1:])
+	if err != nil {
+		return mysql.Position{}, fmt.Errorf("position should be in format 'file:pos', but got '%v', %w", v, err)
+	}
+	return mysql.Position{Name: v[:i], Pos: pos}, nil
+}
+
+// Whether the pipeline should be registered in replay mode.
+func (c ReplayConfig) includePipeline(p Pipeline) bool {
+	if len(c.Pipelines) < 1 {
+		return true
+	}
+	for _, v := range c.Pipelines {
+		if strings.TrimSpace(v) == p.Stream {
+			return true
+		}
+	}
+	return false
+}
+
+// Replay local binlog files, the events are handled the same way as the ones streamed from master.
+//
+// Binlog position and schema history are never persisted in replay mode.
+func (s *Source) ReplayFiles(rail miso.Rail, c ReplayConfig) error {
+	if err := s.LoadSchemaHistory(rail); err != nil {
+		return err
+	}
+
+	if c.FetchSchema {
+		if _, err := s.PrepareSync(rail); err != nil {
+			return fmt.Errorf("failed to connect to master instance of source '%v', %w", s.Name, err)
+		}
+		defer s.closeSync()
+	}
+
+	parser := replication.NewBinlogParser()
+	parser.SetFlavor(s.flavor())
+
+	started := c.StartPos.Name == ""
+	for _, f := range c.Files {
+		name := filepath.Base(f)
+		var offset int64
+		if !started {
+			if name != c.StartPos.Name {
+				rail.Infof("Skipped binlog file '%v', before start position %v", f, c.StartPos)
+				continue
+			}
+			started = true
+			offset = int64(c.StartPos.Pos)
+		}
+
+		s.posMu.Lock()
+		s.nextPos = BinlogPos{Position: mysql.Position{Name: name, Pos: 4}}
+		s.posMu.Unlock()
+		s.abortTx(rail)
+
+		rail.Infof("Replaying binlog file '%v' of source '%v', offset: %v", f, s.Name, offset)
+		err := parser.ParseFile(f, offset, func(ev *replication.BinlogEvent) error {
+			if miso.IsShuttingDown() || rail.Context().Err() != nil {
+				return errReplayStopped
+			}
+			if c.StopPos.Name != "" && name == c.StopPos.Name && ev.Header.LogPos > 0 &&
+				ev.Header.LogPos-ev.Header.EventSize >= c.StopPos.Pos {
+				return errReplayStopped
+			}
+			return s.handleEvent(miso.EmptyRail(), ev)
+		})
+		if errors.Is(err, errReplayStopped) {
+			rail.Infof("Replay stopped at %v:%v", name, c.StopPos.Pos)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay binlog file '%v', %w", f, err)
+		}
+		if c.StopPos.Name != "" && name == c.StopPos.Name {
+			return nil
+		}
+	}
+	if !started {
+		return fmt.Errorf("binlog file of start position %v is not found in '%v'", c.StartPos, PropReplayFiles)
+	}
+	return nil
+}
+
+func startReplay(rail miso.Rail) error {
+	c, err := LoadReplayConfig()
+	if err != nil {
+		return err
+	}
+	name := miso.GetPropStrTrimmed(PropReplaySource)
+	if name == "" {
+		name = miso.GetPropStrTrimmed(PropSyncName)
+	}
+	s, ok := FindSource(name)
+	if !ok {
+		return fmt.Errorf("source '%v' of replay is not found", name)
+	}
+	if !HasAnyEventHandler() {
+		OnEventReceived(defaultLogHandler)
+	}
+
+	nrail, cancel := rail.NextSpan().WithCancel()
+	miso.AddShutdownHook(func() {
+		cancel()
+		pumpEventWg.Wait()
+	})
+
+	pumpEventWg.Add(1)
+	go func(rail miso.Rail) {
+		defer func() {
+			pumpEventWg.Done()
+			miso.Shutdown()
+		}()
+		if err := s.ReplayFiles(rail, c); err != nil {
+			rail.Errorf("Replay of source '%v' failed, %v", s.Name, err)
+			return
+		}
+		rail.Infof("Replay of source '%v' finished", s.Name)
+	}(nrail)
+	return nil
+}
@@ -0,0 +1,87 @@
+package pump
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestParseFilePos(t *testing.T) {
+	p, err := parseFilePos("binlog.000001:1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (mysql.Position{Name: "binlog.000001", Pos: 1234}) {
+		t.Fatalf("%+v", p)
+	}
+
+	for _, v := range []string{"binlog.000001", ":4", "binlog.000001:abc"} {
+		if _, err := parseFilePos(v); err == nil {
+			t.Fatalf("'%v' should be invalid", v)
+		}
+	}
+}
+
+func TestReplayIncludePipeline(t *testing.T) {
+	c := ReplayConfig{}
+	if !c.includePipeline(Pipeline{Stream: "order-change"}) {
+		t.Fatal("all pipelines should be included")
+	}
+	c.Pipelines = []string{"order-change", " user-change "}
+	if !c.includePipeline(Pipeline{Stream: "user-change"}) {
+		t.Fatal("user-change should be included")
+	}
+	if c.includePipeline(Pipeline{Stream: "product-change"}) {
+		t.Fatal("product-change should be excluded")
+	}
+}
+
+func TestReplayDDLWithoutConnection(t *testing.T) {
+	s := newTestSource(t, flavorMysql) // replay.fetch-schema=false, not connected to master
+	s.nextPos = BinlogPos{Position: mysql.Position{Name: "binlog.000001", Pos: 4}}
+	rail := miso.EmptyRail()
+
+	var received []DataChangeEvent
+	id := OnEventReceived(func(c miso.Rail, dce DataChangeEvent, ctx *EventHandleContext) error {
+		received = append(received, dce)
+		return nil
+	})
+	defer RemoveEventHandler(id)
+
+	ev := func(typ replication.EventType, e replication.Event, logPos uint32) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: typ, LogPos: logPos}, Event: e}
+	}
+	query := func(q string, logPos uint32) *replication.BinlogEvent {
+		return ev(replication.QUERY_EVENT, &replication.QueryEvent{Schema: []byte("my_db"), Query: []byte(q)}, logPos)
+	}
+	tme := &replication.TableMapEvent{Schema: []byte("my_db"), Table: []byte("my_table"), ColumnCount: 2,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VARCHAR}, ColumnMeta: []uint16{0, 40}} // without metadata
+
+	events := []*replication.BinlogEvent{
+		query("create table my_table (id bigint primary key, name varchar(10))", 200),
+		query("alter table other_table add column name varchar(10)", 300), // unknown table, ignored
+		query("BEGIN", 400),
+		ev(replication.TABLE_MAP_EVENT, tme, 450),
+		ev(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{Table: tme, Rows: [][]any{{int64(1), "apple"}}}, 500),
+		ev(replication.XID_EVENT, &replication.XIDEvent{}, 530),
+	}
+	for _, e := range events {
+		if err := s.handleEvent(rail, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(received) != 3 {
+		t.Fatalf("expected 3 events, received: %v", received)
+	}
+	if received[0].Type != TypeDDL || len(received[0].Columns) != 2 || received[1].Type != TypeDDL || received[1].Table != "other_table" {
+		t.Fatalf("%v, %v", received[0], received[1])
+	}
+	ins := received[2]
+	if ins.Type != TypeInsert || len(ins.Records) != 1 || len(ins.Columns) != 2 ||
+		ins.Columns[0].Name != "id" || ins.Columns[1].Name != "name" || !ins.Columns[0].PrimaryKey {
+		t.Fatalf("%v", ins)
+	}
+}
@@ -0,0 +1,300 @@
+package pump
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util/osutil"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+const (
+	PropSyncSchemaHistoryFile = "sync.schema-history.file"
+
+	// max number of schema versions kept for each table
+	maxSchemaVersions = 20
+)
+
+func init() {
+	miso.SetDefProp(PropSyncSchemaHistoryFile, "binlog_schema_history")
+}
+
+// Table definition that takes effect since the binlog position.
+//
+// Columns is nil if the table is changed by DDL at the position, but the definition after the DDL is unknown,
+// e.g., the DDL can't be applied to the previous version, or the table is dropped.
+type SchemaVersion struct {
+	Pos     mysql.Position
+	Columns []ColumnInfo
+}
+
+// Schema history records table definitions keyed by binlog position.
+//
+// When event-pump is lagging behind or the binlog is replayed from an old position,
+// RowsEvent must be decoded using the table definition at the time the rows were written,
+// instead of the current one in information_schema.
+//
+// A new version is recorded whenever the table is changed by DDL, it's derived by applying the DDL to the previous version.
+// If the version can't be derived, it's fetched from information_schema when the rows are received,
+// and the fetched version takes effect since the position of the DDL.
+//
+// Binlog positions of different servers can't be compared, e.g., after GTID based failover,
+// so the history is reset once the source is connected to a different server.
+type schemaHistory struct {
+	mu     sync.Mutex
+	server string                     // server_uuid (MySQL) or server_id (MariaDB) of the server, that the positions belong to
+	tables map[string][]SchemaVersion // sorted by position
+	dirty  bool
+}
+
+// Persisted schema history.
+type schemaHistoryFile struct {
+	Server string
+	Tables map[string][]SchemaVersion
+}
+
+func newSchemaHistory() *schemaHistory {
+	return &schemaHistory{
+		tables: map[string][]SchemaVersion{},
+	}
+}
+
+// Lookup the table definition at the position.
+func (h *schemaHistory) Lookup(table string, pos mysql.Position) (SchemaVersion, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v, ok := h.latest(table, pos)
+	return v, ok && v.Columns != nil
+}
+
+// Record table definition fetched at the position.
+//
+// If the table was changed by DDL and the definition after the DDL is unknown, the version takes effect since the position of the DDL instead.
+func (h *schemaHistory) Record(table string, pos mysql.Position, columns []ColumnInfo) SchemaVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if v, ok := h.latest(table, pos); ok && v.Columns == nil {
+		pos = v.Pos
+	}
+	return h.put(table, SchemaVersion{Pos: pos, Columns: columns})
+}
+
+// Table is changed by DDL at the position, columns is the definition after the DDL, nil if it's unknown or the table is dropped.
+func (h *schemaHistory) OnDDL(table string, pos mysql.Position, columns []ColumnInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if columns == nil {
+		// DDL is replayed, the definition after the DDL is already known
+		if v, ok := h.latest(table, pos); ok && v.Pos.Compare(pos) == 0 && v.Columns != nil {
+			return
+		}
+	}
+	h.put(table, SchemaVersion{Pos: pos, Columns: columns})
+}
+
+// Latest version that takes effect at the position.
+func (h *schemaHistory) latest(table string, pos mysql.Position) (SchemaVersion, bool) {
+	versions := h.tables[table]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Pos.Compare(pos) <= 0 {
+			return versions[i], true
+		}
+	}
+	return SchemaVersion{}, false
+}
+
+func (h *schemaHistory) put(table string, v SchemaVersion) SchemaVersion {
+	versions := h.tables[table]
+	replaced := false
+	for i := range versions {
+		if versions[i].Pos.Compare(v.Pos) == 0 {
+			versions[i] = v
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		versions = append(versions, v)
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Pos.Compare(versions[j].Pos) < 0 })
+	}
+	if len(versions) > maxSchemaVersions {
+		versions = versions[len(versions)-maxSchemaVersions:]
+	}
+	h.tables[table] = versions
+	h.dirty = true
+	return v
+}
+
+// Source is connected to the server, true is returned if the history is reset, because it was recorded on a different server.
+//
+// History recorded before the server is known is assumed to be recorded on the same server.
+func (h *schemaHistory) OnServer(server string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.server == server {
+		return false
+	}
+	reset := h.server != "" && len(h.tables) > 0
+	if reset {
+		h.tables = map[string][]SchemaVersion{}
+	}
+	h.server = server
+	h.dirty = true
+	return reset
+}
+
+// Names of tables in the schema that have schema history.
+func (h *schemaHistory) Tables(schema string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prefix := schema + "."
+	tables := []string{}
+	for k := range h.tables {
+		if strings.HasPrefix(k, prefix) {
+			tables = append(tables, strings.TrimPrefix(k, prefix))
+		}
+	}
+	return tables
+}
+
+func (h *schemaHistory) marshalIfDirty() ([]byte, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return nil, false, nil
+	}
+	buf, err := json.Marshal(schemaHistoryFile{Server: h.server, Tables: h.tables})
+	if err != nil {
+		return nil, false, err
+	}
+	h.dirty = false
+	return buf, true, nil
+}
+
+func (h *schemaHistory) load(buf []byte) error {
+	var f schemaHistoryFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return err
+	}
+	if f.Tables == nil {
+		// written by older versions, tables are not wrapped, and the server is unknown
+		f.Tables = map[string][]SchemaVersion{}
+		if err := json.Unmarshal(buf, &f.Tables); err != nil {
+			return err
+		}
+		f.Server = ""
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.server = f.Server
+	h.tables = f.Tables
+	h.dirty = false
+	return nil
+}
+
+func (s *Source) LoadSchemaHistory(rail miso.Rail) error {
+	buf, err := s.doReadSchemaHistFunc(rail)
+	if err != nil {
+		return fmt.Errorf("failed to read schema history, %w", err)
+	}
+	if len(buf) < 1 {
+		return nil
+	}
+	if err := s.schemaHist.load(buf); err != nil {
+		return fmt.Errorf("failed to parse schema history, %w", err)
+	}
+	rail.Infof("Loaded schema history of source '%v', %d tables", s.Name, len(s.schemaHist.tables))
+	return nil
+}
+
+// Reset schema history if the source is connected to a different server, e.g., after failover.
+func (s *Source) checkSchemaHistoryServer(rail miso.Rail) error {
+	server, err := s.detectServerIdentity()
+	if err != nil {
+		return err
+	}
+	if s.schemaHist.OnServer(server) {
+		rail.Warnf("Source '%v' is connected to a different server '%v', binlog positions in schema history can't be compared, schema history is reset",
+			s.Name, server)
+	}
+	return nil
+}
+
+// Flush schema history, it should be flushed before the binlog position.
+func (s *Source) FlushSchemaHistory() error {
+	buf, ok, err := s.schemaHist.marshalIfDirty()
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema history, %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	if err := s.doFlushSchemaHistFunc(buf); err != nil {
+		s.schemaHist.mu.Lock()
+		s.schemaHist.dirty = true
+		s.schemaHist.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *Source) readLocalSchemaHistFile(rail miso.Rail) ([]byte, error) {
+	f := s.conf.SchemaHistoryFile
+	if f == "" {
+		return nil, nil
+	}
+	ok, err := osutil.FileExists(f)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return osutil.ReadFileAll(f)
+}
+
+func (s *Source) flushLocalSchemaHistFile(byt []byte) error {
+	f := s.conf.SchemaHistoryFile
+	if f == "" {
+		return nil
+	}
+	return overwriteLocalFile(f, "schema history", byt)
+}
+
+// Overwrite the local file, it's written to temp file first, then renamed to target file.
+func overwriteLocalFile(f string, desc string, byt []byte) error {
+	tmp := f + "_buffer"
+	tf, err := osutil.OpenRWFile(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to open %v file: %v, %w", desc, tmp, err)
+	}
+	defer tf.Close()
+
+	_ = tf.Truncate(0)
+	if _, err := tf.WriteAt(byt, 0); err != nil {
+		return fmt.Errorf("failed to write %v file: %v, %w", desc, tmp, err)
+	}
+	if err := tf.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync %v file: %v, %w", desc, tmp, err)
+	}
+	if err := os.Rename(tmp, f); err != nil {
+		return fmt.Errorf("failed to overwrite %v file: %v, %w", desc, f, err)
+	}
+	return nil
+}
+
+func (s *Source) flushZkSchemaHistFile(byt []byte) error {
+	return ZkWrite(s.zkPath(ZkPathSchemaHistory), byt)
+}
+
+func (s *Source) readZkSchemaHistFile(rail miso.Rail) ([]byte, error) {
+	return ZkRead(s.zkPath(ZkPathSchemaHistory))
+}
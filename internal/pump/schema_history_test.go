@@ -0,0 +1,122 @@
+package pump
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestSchemaHistory(t *testing.T) {
+	h := newSchemaHistory()
+	tab := "my_db.my_table"
+	pos := func(name string, p uint32) mysql.Position { return mysql.Position{Name: name, Pos: p} }
+	v1 := []ColumnInfo{{ColumnName: "id"}, {ColumnName: "name"}}
+	v2 := []ColumnInfo{{ColumnName: "id"}, {ColumnName: "age"}, {ColumnName: "name"}}
+
+	if _, ok := h.Lookup(tab, pos("binlog.000001", 100)); ok {
+		t.Fatal("should be empty")
+	}
+	h.Record(tab, pos("binlog.000001", 100), v1)
+
+	// alter table at binlog.000001:200, the definition after the DDL is unknown
+	h.OnDDL(tab, pos("binlog.000001", 200), nil)
+	if v, ok := h.Lookup(tab, pos("binlog.000001", 150)); !ok || len(v.Columns) != 2 {
+		t.Fatalf("should use the version before DDL, %+v", v)
+	}
+	if _, ok := h.Lookup(tab, pos("binlog.000002", 10)); ok {
+		t.Fatal("table changed by DDL, should be refetched")
+	}
+
+	// fetched later, but the version takes effect since the DDL
+	v := h.Record(tab, pos("binlog.000002", 10), v2)
+	if v.Pos != pos("binlog.000001", 200) {
+		t.Fatalf("%+v", v.Pos)
+	}
+
+	// replayed from old position
+	buf, ok, err := h.marshalIfDirty()
+	if err != nil || !ok {
+		t.Fatal(err)
+	}
+	h = newSchemaHistory()
+	if err := h.load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := h.Lookup(tab, pos("binlog.000001", 120)); !ok || len(v.Columns) != 2 {
+		t.Fatalf("%+v", v)
+	}
+	h.OnDDL(tab, pos("binlog.000001", 200), nil)
+	if v, ok := h.Lookup(tab, pos("binlog.000001", 300)); !ok || len(v.Columns) != 3 {
+		t.Fatalf("%+v", v)
+	}
+
+	// alter table at binlog.000002:100, the definition is derived from the DDL
+	v3 := []ColumnInfo{{ColumnName: "id"}, {ColumnName: "name"}}
+	h.OnDDL(tab, pos("binlog.000002", 100), v3)
+	if v, ok := h.Lookup(tab, pos("binlog.000002", 50)); !ok || len(v.Columns) != 3 {
+		t.Fatalf("should use the version before DDL, %+v", v)
+	}
+	if v, ok := h.Lookup(tab, pos("binlog.000002", 150)); !ok || len(v.Columns) != 2 || v.Pos != pos("binlog.000002", 100) {
+		t.Fatalf("%+v", v)
+	}
+
+	// drop table
+	h.OnDDL(tab, pos("binlog.000002", 200), nil)
+	if _, ok := h.Lookup(tab, pos("binlog.000002", 250)); ok {
+		t.Fatal("table dropped, should be refetched")
+	}
+}
+
+func TestSchemaHistoryServerChanged(t *testing.T) {
+	tab := "my_db.my_table"
+	pos := mysql.Position{Name: "binlog.000001", Pos: 100}
+	v1 := []ColumnInfo{{ColumnName: "id"}, {ColumnName: "name"}}
+
+	// written by older versions, the server is unknown
+	h := newSchemaHistory()
+	if err := h.load([]byte(`{"my_db.my_table":[{"Pos":{"Name":"binlog.000001","Pos":100},"Columns":[{"ColumnName":"id"}]}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if h.OnServer("uuid-a") {
+		t.Fatal("history recorded before the server is known shouldn't be reset")
+	}
+	if v, ok := h.Lookup(tab, pos); !ok || len(v.Columns) != 1 {
+		t.Fatalf("%+v", v)
+	}
+	h.Record(tab, pos, v1)
+
+	// restarted, connected to the same server
+	buf, ok, err := h.marshalIfDirty()
+	if err != nil || !ok {
+		t.Fatal(err)
+	}
+	h = newSchemaHistory()
+	if err := h.load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if h.OnServer("uuid-a") {
+		t.Fatal("same server, shouldn't be reset")
+	}
+	if v, ok := h.Lookup(tab, pos); !ok || len(v.Columns) != 2 {
+		t.Fatalf("%+v", v)
+	}
+
+	// failover, positions of the new server can't be compared with the recorded ones
+	if !h.OnServer("uuid-b") {
+		t.Fatal("should be reset")
+	}
+	if _, ok := h.Lookup(tab, pos); ok {
+		t.Fatal("should be reset")
+	}
+	buf, ok, err = h.marshalIfDirty()
+	if err != nil || !ok {
+		t.Fatal(err)
+	}
+	h = newSchemaHistory()
+	if err := h.load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if h.server != "uuid-b" || len(h.tables) != 0 {
+		t.Fatalf("%v, %v", h.server, h.tables)
+	}
+}
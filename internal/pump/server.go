@@ -20,7 +20,6 @@ import (
 	"github.com/curtisnewbie/miso/util/osutil"
 	"github.com/curtisnewbie/miso/util/slutil"
 	"github.com/curtisnewbie/miso/util/strutil"
-	"github.com/go-mysql-org/go-mysql/replication"
 )
 
 const (
@@ -32,6 +31,9 @@ const (
 
 var (
 	asyncDispatchMQPool async.AsyncPool
+
+	// publish event to the event bus, replaced in tests
+	pubEventBus = rabbit.PubEventBus
 )
 
 var (
@@ -86,17 +88,28 @@ func PreServerBootstrap(rail miso.Rail) error {
 		config.Pipelines[i] = p
 	}
 
-	if config.Filter.Include != "" {
-		SetGlobalInclude(regexp.MustCompile(config.Filter.Include))
+	ss, err := LoadSources(config)
+	if err != nil {
+		return err
 	}
-
-	if config.Filter.Exclude != "" {
-		SetGlobalExclude(regexp.MustCompile(config.Filter.Exclude))
+	if err := RegisterSources(ss...); err != nil {
+		return err
 	}
 
 	config.Pipelines = append(config.Pipelines, loadLocalConfigs(rail)...)
 
+	var replay ReplayConfig
+	if isReplayMode() {
+		if replay, err = LoadReplayConfig(); err != nil {
+			return err
+		}
+	}
+
 	for _, p := range config.Pipelines {
+		if isReplayMode() && !replay.includePipeline(p) {
+			rail.Infof("Pipeline '%v' is not included in replay, skipped", p.Stream)
+			continue
+		}
 		if err := AddPipeline(rail, p); err != nil {
 			return err
 		}
@@ -202,6 +215,9 @@ func samePipeline(a Pipeline, b Pipeline) bool {
 		a.Table == b.Table &&
 		a.Type == b.Type &&
 		a.Stream == b.Stream &&
+		a.Source == b.Source &&
+		a.Transactional == b.Transactional &&
+		a.Format == b.Format &&
 		sameCondition(a.Condition, b.Condition)
 }
 
@@ -223,9 +239,13 @@ func sameCondition(a Condition, b Condition) bool {
 type ApiPipeline struct {
 	Schema     string    `desc:"schema name"`
 	Table      string    `desc:"table name"`
-	EventTypes []string  `desc:"event types; INS - Insert, UPD - Update, DEL - Delete"`
+	EventTypes []string  `desc:"event types; INS - Insert, UPD - Update, DEL - Delete, DDL - Schema Change, SNAP - Snapshot"`
 	Stream     string    `desc:"event bus name"`
 	Condition  Condition `desc:"extra filtering conditions"`
+
+	Transactional bool   `desc:"publish one message per committed transaction that contains all the row changes"`
+	Format        string `desc:"event format; v1 (default) - column values as strings, v2 - typed column values"`
+	Source        string `desc:"name of the source; if empty, events from all sources are subscribed"`
 }
 
 func (p ApiPipeline) Pipeline() Pipeline {
@@ -235,6 +255,9 @@ func (p ApiPipeline) Pipeline() Pipeline {
 	pl.Type = pipelineTypeRegex(p.EventTypes)
 	pl.Stream = p.Stream
 	pl.Condition = p.Condition
+	pl.Transactional = p.Transactional
+	pl.Format = p.Format
+	pl.Source = p.Source
 	pl.Enabled = true
 	return pl
 }
@@ -272,6 +295,10 @@ func copyApiPipelines() []ApiPipeline {
 				EventTypes: p.Types,
 				Stream:     p.Stream,
 				Condition:  p.Condition,
+
+				Transactional: p.Transactional,
+				Format:        p.Format,
+				Source:        p.Source,
 			}
 		})
 		cp = append(cp, cvt...)
@@ -322,6 +349,19 @@ func AddPipeline(rail miso.Rail, pipeline Pipeline) error {
 	pipeline.Table = strings.TrimSpace(pipeline.Table)
 	pipeline.Type = strings.TrimSpace(pipeline.Type)
 	pipeline.Stream = strings.TrimSpace(pipeline.Stream)
+	pipeline.Format = strings.ToLower(strings.TrimSpace(pipeline.Format))
+	if pipeline.Format == FormatV1 {
+		pipeline.Format = ""
+	}
+	if pipeline.Format != "" && pipeline.Format != FormatV2 {
+		return fmt.Errorf("invalid pipeline.format: '%v'", pipeline.Format)
+	}
+	pipeline.Source = strings.TrimSpace(pipeline.Source)
+	if pipeline.Source != "" {
+		if _, ok := FindSource(pipeline.Source); !ok {
+			return fmt.Errorf("invalid pipeline.source: '%v', source not found", pipeline.Source)
+		}
+	}
 	for i, c := range pipeline.Condition.ColumnChanged {
 		pipeline.Condition.ColumnChanged[i] = strings.TrimSpace(c)
 	}
@@ -350,15 +390,30 @@ func AddPipeline(rail miso.Rail, pipeline Pipeline) error {
 	filters := NewFilters(pipeline)
 
 	// mapper for converting the structure of the event
-	mapper := NewMapper()
+	mapper := NewMapper(pipeline.Format)
 
 	// Declare Stream
 	rabbit.NewEventBus(pipeline.Stream)
 
 	var dispatchErr error
 	dispatchErrMut := &sync.RWMutex{}
+	onDispatchErr := func(err error) {
+		if err != nil {
+			dispatchErrMut.Lock()
+			defer dispatchErrMut.Unlock()
+			dispatchErr = err
+		}
+	}
+
+	// events of the current transaction of each source, only used when pipeline.Transactional is true
+	txEvents := map[string][]any{}
+	txEventsMu := &sync.Mutex{}
 
 	handlerId := OnEventReceived(func(c miso.Rail, dce DataChangeEvent, ctx *EventHandleContext) error {
+		if pipeline.Source != "" && pipeline.Source != dce.Source {
+			c.Debugf("source not matched, event ignored, %v", dce.Source)
+			return nil
+		}
 		if !schemaPattern.MatchString(dce.Schema) {
 			c.Debugf("schema pattern not matched, event ignored, %v", dce.Schema)
 			return nil
@@ -371,6 +426,10 @@ func AddPipeline(rail miso.Rail, pipeline Pipeline) error {
 			c.Debugf("type pattern not matched, event ignored, %v", dce.Type)
 			return nil
 		}
+		if typePattern == nil && dce.Type == TypeDDL {
+			// DDL events must be subscribed explicitly
+			return nil
+		}
 
 		// based on configuration, we may convert the dce to some sort of structure meaningful to the receiver
 		// one change event may be manified to multple events, e.g., an update to multiple rows
@@ -399,21 +458,27 @@ func AddPipeline(rail miso.Rail, pipeline Pipeline) error {
 		}
 		dispatchErrMut.RUnlock()
 
+		if pipeline.Transactional {
+			// buffered until the transaction is committed
+			txEventsMu.Lock()
+			defer txEventsMu.Unlock()
+			for _, evt := range events {
+				if includeEvent(c, filters, evt) {
+					// published along with the transaction, duplicate pipelines of the same stream shouldn't buffer it again
+					ctx.StreamDispatched.Add(pipeline.Stream)
+					txEvents[dce.Source] = append(txEvents[dce.Source], evt)
+				}
+			}
+			return nil
+		}
+
 		// for higher throughput, processing a few extra events before we notice the error is acceptable
 		asyncDispatchMQPool.Run(func() error {
 
 			for _, evt := range events {
-				anyMatch := false
-				for _, filter := range filters {
-					if filter.Include(c, evt) {
-						anyMatch = true
-						break
-					}
-				}
-
-				if anyMatch {
+				if includeEvent(c, filters, evt) {
 					ctx.StreamDispatched.Add(pipeline.Stream)
-					if err := rabbit.PubEventBus(c, evt, pipeline.Stream); err != nil {
+					if err := pubEventBus(c, evt, pipeline.Stream); err != nil {
 						return err
 					}
 					if !isProd {
@@ -424,56 +489,97 @@ func AddPipeline(rail miso.Rail, pipeline Pipeline) error {
 			}
 			return nil
 
-		}).ThenErr(func(err error) {
-			if err != nil {
-				dispatchErrMut.Lock()
-				defer dispatchErrMut.Unlock()
-				dispatchErr = err
-			}
-		})
+		}).ThenErr(onDispatchErr)
 
 		return nil
 	})
 
+	if pipeline.Transactional {
+		OnTxAborted(handlerId, func(c miso.Rail, source string) {
+			txEventsMu.Lock()
+			defer txEventsMu.Unlock()
+			delete(txEvents, source)
+		})
+		OnTxCommitted(handlerId, func(c miso.Rail, tx TxInfo) error {
+			txEventsMu.Lock()
+			buffered := txEvents[tx.Source]
+			delete(txEvents, tx.Source)
+			txEventsMu.Unlock()
+
+			if len(buffered) < 1 {
+				return nil
+			}
+			txe := TxStreamEvent{Source: tx.Source, TxId: tx.Id, Timestamp: tx.Timestamp, Events: buffered}
+
+			dispatchErrMut.RLock()
+			if err := dispatchErr; err != nil {
+				defer dispatchErrMut.RUnlock()
+				return err
+			}
+			dispatchErrMut.RUnlock()
+
+			asyncDispatchMQPool.Run(func() error {
+				if err := pubEventBus(c, txe, pipeline.Stream); err != nil {
+					return err
+				}
+				if !miso.IsProdMode() {
+					c.Infof("Transactional Event Pipeline triggered, tx: '%v', events: %d, event-bus: %s", txe.TxId, len(txe.Events), pipeline.Stream)
+				}
+				return nil
+			}).ThenErr(onDispatchErr)
+			return nil
+		})
+	}
+
 	pipeline.HandlerId = handlerId
 	pipelineMap[pk] = append(pipelineMap[pk], pipeline)
 
-	rail.Infof("Subscribed binlog events, schema: '%v', table: '%v', type: '%v', event-bus: %s, conditions: %+v",
-		pipeline.Schema, pipeline.Table, pipeline.Type, pipeline.Stream, pipeline.Condition)
+	// pipeline added after the initial snapshot
+	for _, s := range Sources() {
+		if pipeline.Source == "" || pipeline.Source == s.Name {
+			s.requestSnapshot()
+		}
+	}
+
+	rail.Infof("Subscribed binlog events, source: '%v', schema: '%v', table: '%v', type: '%v', event-bus: %s, conditions: %+v, transactional: %v, format: '%v'",
+		pipeline.Source, pipeline.Schema, pipeline.Table, pipeline.Type, pipeline.Stream, pipeline.Condition, pipeline.Transactional, pipeline.Format)
 	return nil
 }
 
+func includeEvent(rail miso.Rail, filters []Filter, evt any) bool {
+	for _, filter := range filters {
+		if filter.Include(rail, evt) {
+			return true
+		}
+	}
+	return false
+}
+
 func PostServerBootstrap(rail miso.Rail) error {
 
 	haMode := isHaMode()
-	SetupPosFileStorage(haMode)
+	ss := Sources()
+	if len(ss) < 1 {
+		return errors.New("no source is configured, please configure sync.* or source list")
+	}
+	for _, s := range ss {
+		s.SetupPosFileStorage(haMode)
+	}
+
+	if isReplayMode() {
+		return startReplay(rail)
+	}
 
 	if !haMode {
 		pipelineConfigSyncTick.Start()
 	}
 
 	startSync := func() {
-		if err := AttachPos(rail); err != nil {
-			panic(fmt.Errorf("failed to attach pos file, %v", err))
-		}
-
-		syncer, err := PrepareSync(rail)
-		if err != nil {
-			DetachPos(rail)
-			panic(fmt.Errorf("failed to create syncer, %v", err))
-		}
-
-		streamer, err := NewStreamer(rail, syncer)
-		if err != nil {
-			DetachPos(rail)
-			panic(fmt.Errorf("failed to create streamer, %v", err))
-		}
-
 		if !HasAnyEventHandler() {
 			OnEventReceived(defaultLogHandler)
 		}
 
-		// make sure the goroutine exit before the server stops
+		// make sure the goroutines exit before the server stops
 		nrail, cancel := rail.NextSpan().WithCancel()
 		miso.AddShutdownHook(func() {
 			if !haMode {
@@ -483,20 +589,9 @@ func PostServerBootstrap(rail miso.Rail) error {
 			pumpEventWg.Wait()
 		})
 
-		pumpEventWg.Add(1)
-		go func(rail miso.Rail, streamer *replication.BinlogStreamer) {
-			defer func() {
-				syncer.Close()
-				DetachPos(rail)
-				pumpEventWg.Done()
-				miso.Shutdown()
-			}()
-
-			if e := PumpEvents(rail, syncer, streamer); e != nil {
-				rail.Errorf("PumpEvents encountered error: %v, exiting", e)
-				return
-			}
-		}(nrail, streamer)
+		for _, s := range ss {
+			startSource(nrail, s)
+		}
 	}
 
 	if haMode {
@@ -510,6 +605,66 @@ func PostServerBootstrap(rail miso.Rail) error {
 	return nil
 }
 
+// Start streaming of the source in a separate goroutine.
+//
+// The initial snapshot may take a while, it shouldn't block the bootstrap or the leader election.
+// Server is shutdown if the stream can't be started or recovered.
+func startSource(rail miso.Rail, s *Source) {
+	pumpEventWg.Add(1)
+	go func(rail miso.Rail) {
+		defer pumpEventWg.Done()
+
+		if err := runSource(rail, s); err != nil && !miso.IsShuttingDown() {
+			rail.Errorf("%v", err)
+			s.failed.Store(true)
+			if shutdownOnSourceFailure(Sources()) {
+				miso.Shutdown()
+			}
+		}
+	}(rail)
+}
+
+// Run the stream of the source until the server is shutting down or the stream fails.
+func runSource(rail miso.Rail, s *Source) error {
+	if err := s.AttachPos(rail); err != nil {
+		return fmt.Errorf("failed to attach pos file of source '%v', %w", s.Name, err)
+	}
+	defer func() {
+		s.closeSync()
+		s.DetachPos(rail)
+	}()
+
+	if err := s.startStream(rail); err != nil {
+		if errors.Is(err, errSnapshotStopped) {
+			rail.Infof("Snapshot of source '%v' stopped, exiting", s.Name)
+			return nil
+		}
+		return fmt.Errorf("failed to start stream of source '%v', %w", s.Name, err)
+	}
+	s.requestSnapshot()
+
+	if e := s.SuperviseStream(rail); e != nil {
+		return fmt.Errorf("stream of source '%v' encountered error, exiting, %w", s.Name, e)
+	}
+	return nil
+}
+
+// Whether the server should shut down when a source fails.
+//
+// The failure is contained to the source (it's reported as unhealthy), unless `sync.shutdown-on-source-failure` is enabled,
+// or none of the sources is still running.
+func shutdownOnSourceFailure(ss []*Source) bool {
+	if miso.GetPropBool(PropSyncShutdownOnSourceFailure) {
+		return true
+	}
+	for _, s := range ss {
+		if !s.failed.Load() {
+			return false
+		}
+	}
+	return true
+}
+
 func BootstrapServer(args []string) {
 	miso.PreServerBootstrap(PreServerBootstrap)
 	miso.PostServerBootstrap(PostServerBootstrap)
@@ -535,8 +690,12 @@ func prepareHealthIndicator() {
 	miso.AddHealthIndicator(miso.HealthIndicator{
 		Name: "Binlog Health Indicator",
 		CheckHealth: func(rail miso.Rail) bool {
-			healthy := checkBinlogHealth()
-			rail.Debugf("Binlog polling is healthy: %v", healthy)
+			healthy := true
+			for _, s := range Sources() {
+				ok := s.checkBinlogHealth()
+				rail.Debugf("Binlog polling of source '%v' is healthy: %v", s.Name, ok)
+				healthy = healthy && ok
+			}
 			return healthy
 		},
 	})
@@ -0,0 +1,153 @@
+package pump
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestTransactionalPipeline(t *testing.T) {
+	var mu sync.Mutex
+	published := map[string][]any{}
+	defer func(f func(miso.Rail, any, string) error) { pubEventBus = f }(pubEventBus)
+	pubEventBus = func(rail miso.Rail, eventObject any, name string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		published[name] = append(published[name], eventObject)
+		return nil
+	}
+
+	// wait for the async dispatch
+	await := func(stream string, n int) []any {
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			mu.Lock()
+			events := append([]any{}, published[stream]...)
+			mu.Unlock()
+			if len(events) >= n || time.Now().After(deadline) {
+				return events
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	rail := miso.EmptyRail()
+	p := Pipeline{Schema: "^my_db$", Table: "^my_table$", Stream: "test-tx-change", Transactional: true, Enabled: true}
+	if err := AddPipeline(rail, p); err != nil {
+		t.Fatal(err)
+	}
+	defer RemovePipeline(rail, p)
+
+	insert := func(source string, id int64) {
+		dce := DataChangeEvent{
+			Source:  source,
+			Schema:  "my_db",
+			Table:   "my_table",
+			Type:    TypeInsert,
+			Columns: []RecordColumn{{Name: "id", DataType: "bigint"}},
+			Records: []Record{{After: []any{id}}},
+		}
+		if err := callEventHandlers(rail, dce); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(evt any) []string {
+		txe, ok := evt.(TxStreamEvent)
+		if !ok {
+			t.Fatalf("not TxStreamEvent, %#v", evt)
+		}
+		v := []string{}
+		for _, e := range txe.Events {
+			v = append(v, txe.Source+":"+e.(StreamEvent).Columns["id"].After)
+		}
+		return v
+	}
+
+	// buffered until the transaction is committed, events of different sources are not mixed up
+	insert("source-a", 1)
+	insert("source-b", 2)
+	insert("source-a", 3)
+	time.Sleep(50 * time.Millisecond)
+	if events := await("test-tx-change", 0); len(events) > 0 {
+		t.Fatalf("should be buffered until committed, %v", events)
+	}
+	if err := callTxCommitHandlers(rail, TxInfo{Source: "source-a", Id: "tx-a"}); err != nil {
+		t.Fatal(err)
+	}
+	events := await("test-tx-change", 1)
+	if len(events) != 1 || events[0].(TxStreamEvent).TxId != "tx-a" {
+		t.Fatalf("%#v", events)
+	}
+	if v := ids(events[0]); len(v) != 2 || v[0] != "source-a:1" || v[1] != "source-a:3" {
+		t.Fatalf("%v", v)
+	}
+
+	// incomplete transaction is discarded, it's replayed after the stream is restarted
+	callTxAbortHandlers(rail, "source-b")
+	if err := callTxCommitHandlers(rail, TxInfo{Source: "source-b", Id: "tx-b1"}); err != nil {
+		t.Fatal(err)
+	}
+	insert("source-b", 4)
+	if err := callTxCommitHandlers(rail, TxInfo{Source: "source-b", Id: "tx-b2"}); err != nil {
+		t.Fatal(err)
+	}
+	events = await("test-tx-change", 2)
+	if len(events) != 2 || events[1].(TxStreamEvent).TxId != "tx-b2" {
+		t.Fatalf("%#v", events)
+	}
+	if v := ids(events[1]); len(v) != 1 || v[0] != "source-b:4" {
+		t.Fatalf("%v", v)
+	}
+
+	// pipelines of the same stream, the transaction is only published once
+	dup := Pipeline{Schema: "^my_db$", Table: ".*", Type: "^(INS)$", Stream: "test-tx-change", Transactional: true, Enabled: true}
+	if err := AddPipeline(rail, dup); err != nil {
+		t.Fatal(err)
+	}
+	defer RemovePipeline(rail, dup)
+	insert("source-a", 5)
+	if err := callTxCommitHandlers(rail, TxInfo{Source: "source-a", Id: "tx-a2"}); err != nil {
+		t.Fatal(err)
+	}
+	events = await("test-tx-change", 3)
+	time.Sleep(50 * time.Millisecond)
+	if events = await("test-tx-change", 3); len(events) != 3 {
+		t.Fatalf("%#v", events)
+	}
+	if v := ids(events[2]); len(v) != 1 || v[0] != "source-a:5" {
+		t.Fatalf("%v", v)
+	}
+}
+
+func TestShutdownOnSourceFailure(t *testing.T) {
+	a := newTestSource(t, flavorMysql)
+	b := newTestSource(t, flavorMysql)
+	ss := []*Source{a, b}
+
+	// failure is contained to the source
+	a.failed.Store(true)
+	if shutdownOnSourceFailure(ss) {
+		t.Fatal("should keep running other sources")
+	}
+	if a.checkBinlogHealth() {
+		t.Fatal("failed source should be unhealthy")
+	}
+	if !b.checkBinlogHealth() {
+		t.Fatal("other sources should be healthy")
+	}
+
+	// none of the sources is running
+	b.failed.Store(true)
+	if !shutdownOnSourceFailure(ss) {
+		t.Fatal("should shut down")
+	}
+
+	b.failed.Store(false)
+	miso.SetProp(PropSyncShutdownOnSourceFailure, true)
+	defer miso.SetProp(PropSyncShutdownOnSourceFailure, false)
+	if !shutdownOnSourceFailure(ss) {
+		t.Fatal("should shut down when enabled")
+	}
+}
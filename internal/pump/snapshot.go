@@ -0,0 +1,532 @@
+package pump
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	PropSyncSnapshotEnabled    = "sync.snapshot.enabled"
+	PropSyncSnapshotTables     = "sync.snapshot.tables"
+	PropSyncSnapshotChunkSize  = "sync.snapshot.chunk-size"
+	PropSyncSnapshotStateFile  = "sync.snapshot.state-file"
+	PropSyncSnapshotLockTables = "sync.snapshot.lock-tables"
+
+	defaultSnapshotChunkSize = 1000
+
+	// seconds to wait for FLUSH TABLES WITH READ LOCK
+	snapshotLockWaitTimeout = 10
+
+	// attempts to start the consistent snapshot transaction without transactions committed in between
+	snapshotPosAttempts = 5
+)
+
+var (
+	errSnapshotStopped = errors.New("snapshot stopped")
+)
+
+func init() {
+	miso.SetDefProp(PropSyncSnapshotEnabled, false)
+	miso.SetDefProp(PropSyncSnapshotChunkSize, defaultSnapshotChunkSize)
+	miso.SetDefProp(PropSyncSnapshotStateFile, "binlog_snapshot_state")
+	miso.SetDefProp(PropSyncSnapshotLockTables, false)
+}
+
+// Initial snapshot of tables, it's taken when the binlog position is missing (or purged if purged-pos-policy is 'snapshot'),
+// and for the pipelines that are added afterwards.
+type SnapshotConfig struct {
+	Enabled    bool
+	Tables     []string // 'schema.table' or 'schema.*'
+	ChunkSize  int      `mapstructure:"chunk-size"`
+	StateFile  string   `mapstructure:"state-file"`  // pipelines that have received the snapshot
+	LockTables bool     `mapstructure:"lock-tables"` // FLUSH TABLES WITH READ LOCK while the snapshot is started, requires RELOAD privilege
+}
+
+type snapshotTable struct {
+	Schema string
+	Table  string
+}
+
+// Parse 'schema.table', table can be '*' for all tables in the schema.
+func parseSnapshotTable(v string) (snapshotTable, error) {
+	v = strings.TrimSpace(v)
+	i := strings.Index(v, ".")
+	if i < 1 || i == len(v)-1 {
+		return snapshotTable{}, fmt.Errorf("snapshot table should be in format 'schema.table' or 'schema.*', but got '%v'", v)
+	}
+	return snapshotTable{Schema: v[:i], Table: v[i+1:]}, nil
+}
+
+// Publish the rows read in snapshot, tx is the chunk that the rows belong to.
+type snapshotEmitter func(rail miso.Rail, dce DataChangeEvent, tx TxInfo) error
+
+// Publish the rows through all the pipelines.
+func emitToAll(rail miso.Rail, dce DataChangeEvent, tx TxInfo) error {
+	if err := callEventHandlers(rail, dce); err != nil {
+		return err
+	}
+	// transactional pipelines publish one message per chunk
+	return callTxCommitHandlers(rail, tx)
+}
+
+// Take snapshot of the configured tables, and returns the binlog position where streaming should resume from.
+//
+// The rows are published through all the pipelines, the pipelines are then marked as snapshotted.
+func (s *Source) takeSnapshot(rail miso.Rail) (BinlogPos, error) {
+	tables, err := s.resolveSnapshotTables(rail)
+	if err != nil {
+		return BinlogPos{}, err
+	}
+	pipelines := s.pipelines()
+	pos, err := s.doSnapshotFunc(rail, tables, func(t snapshotTable) snapshotEmitter { return emitToAll })
+	if err != nil {
+		return BinlogPos{}, err
+	}
+	if s.conf.Snapshot.Enabled {
+		s.snapState.reset(pipelineSnapshotKeys(pipelines))
+		if err := s.FlushSnapshotState(); err != nil {
+			return BinlogPos{}, err
+		}
+	}
+	return pos, nil
+}
+
+// Take snapshot of the tables for the pipelines that haven't received the snapshot yet, e.g., pipelines added after the initial snapshot.
+//
+// The rows are only published through these pipelines, it's called between transactions, streaming of the source is paused until it's finished.
+//
+// The snapshot is taken at the latest binlog position of master, the stream may be lagging behind,
+// so the pipelines are held at the position, changes before the position are not published through them again.
+func (s *Source) snapshotPendingPipelines(rail miso.Rail) error {
+	if !s.conf.Snapshot.Enabled {
+		return nil
+	}
+	pipelines := s.pipelines()
+	keys := pipelineSnapshotKeys(pipelines)
+	if !s.snapState.isLoaded() {
+		// snapshot state is missing, but the binlog position exists, e.g., upgraded from previous version
+		rail.Infof("Snapshot state of source '%v' missing, existing pipelines are considered snapshotted", s.Name)
+		s.snapState.reset(keys)
+		return s.FlushSnapshotState()
+	}
+	s.snapState.retain(keys)
+
+	pending := []Pipeline{}
+	for _, p := range pipelines {
+		if !s.snapState.contains(pipelineSnapshotKey(p)) {
+			pending = append(pending, p)
+		}
+	}
+	if len(pending) < 1 {
+		return s.FlushSnapshotState()
+	}
+
+	resolved, err := s.resolveSnapshotTables(rail)
+	if err != nil {
+		return err
+	}
+	schemaPatterns := make([]*regexp.Regexp, 0, len(pending))
+	tablePatterns := make([]*regexp.Regexp, 0, len(pending))
+	for _, p := range pending {
+		schemaPatterns = append(schemaPatterns, regexp.MustCompile(p.Schema))
+		tablePatterns = append(tablePatterns, regexp.MustCompile(p.Table))
+	}
+	tables := []snapshotTable{}
+	handlers := map[snapshotTable][]string{} // pipelines of each table
+	for _, t := range resolved {
+		for i, p := range pending {
+			if schemaPatterns[i].MatchString(t.Schema) && tablePatterns[i].MatchString(t.Table) {
+				handlers[t] = append(handlers[t], p.HandlerId)
+			}
+		}
+		if len(handlers[t]) > 0 {
+			tables = append(tables, t)
+		}
+	}
+	if len(tables) > 0 {
+		rail.Infof("Taking snapshot of source '%v' for pipelines: %v", s.Name, pipelineSnapshotKeys(pending))
+		pos, err := s.doSnapshotFunc(rail, tables, func(t snapshotTable) snapshotEmitter {
+			return func(rail miso.Rail, dce DataChangeEvent, tx TxInfo) error {
+				for _, id := range handlers[t] {
+					if err := callEventHandler(rail, id, dce); err != nil {
+						return err
+					}
+					if err := callTxCommitHandler(rail, id, tx); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		})
+		if err != nil {
+			return err
+		}
+		held := []string{}
+		for _, p := range pending {
+			for _, t := range tables {
+				if slices.Contains(handlers[t], p.HandlerId) {
+					held = append(held, pipelineSnapshotKey(p))
+					break
+				}
+			}
+		}
+		s.snapState.hold(held, pos)
+		rail.Infof("Pipelines of source '%v' are held at %+v until the stream passes the position: %v", s.Name, pos, held)
+	}
+	s.snapState.add(pipelineSnapshotKeys(pending))
+	return s.FlushSnapshotState()
+}
+
+// Read the tables from the same consistent view, returns the binlog position of the view.
+//
+// Each table is read in PK order in chunks within the consistent snapshot transaction.
+// Changes made after the recorded position are streamed again once the snapshot is finished.
+func (s *Source) snapshot(rail miso.Rail, tables []snapshotTable, emitter func(t snapshotTable) snapshotEmitter) (BinlogPos, error) {
+	sess, err := s.openSnapshotSession(rail)
+	if err != nil {
+		return BinlogPos{}, err
+	}
+	defer sess.close(rail)
+
+	pos := sess.pos
+	rail.Infof("Taking snapshot of source '%v', tables: %v, binlog position: %+v", s.Name, tables, pos)
+
+	start := time.Now()
+	txId := fmt.Sprintf("snapshot:%s:%d", pos.Name, pos.Pos)
+	seq := 0
+	for _, t := range tables {
+		n, err := s.snapshotTable(rail, sess, t, txId, seq, uint32(start.Unix()), emitter(t))
+		if err != nil {
+			return BinlogPos{}, fmt.Errorf("failed to take snapshot of %v.%v, %w", t.Schema, t.Table, err)
+		}
+		seq += n
+		rail.Infof("Snapshot of %v.%v finished, rows: %v", t.Schema, t.Table, n)
+	}
+	rail.Infof("Snapshot of source '%v' finished, rows: %v, took: %v, resume streaming from %+v", s.Name, seq, time.Since(start), pos)
+	return pos, nil
+}
+
+// Dedicated connection that holds the consistent snapshot transaction.
+type snapshotSession struct {
+	conn *sql.Conn
+	pos  BinlogPos
+}
+
+// Start the consistent snapshot transaction on a dedicated connection, and record the binlog position of the view.
+//
+// By default, the binlog position is recorded right before and after the transaction is started, it's retried until
+// no transaction is committed in between, i.e., the rows read reflect exactly the transactions before the position.
+// If master is too busy, the position before the transaction is used, the transactions committed in between
+// may be included in the view, and they are published again once the streaming catches up.
+//
+// If lock-tables is enabled, writes on master are blocked briefly (FLUSH TABLES WITH READ LOCK) while the transaction is started
+// and the binlog position is recorded, it requires RELOAD privilege.
+func (s *Source) openSnapshotSession(rail miso.Rail) (*snapshotSession, error) {
+	db, err := s.conn.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(rail.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot connection, %w", err)
+	}
+	exec := func(stmt string) error {
+		if _, err := conn.ExecContext(rail.Context(), stmt); err != nil {
+			return fmt.Errorf("failed to execute '%v', %w", stmt, err)
+		}
+		return nil
+	}
+
+	pos, err := func() (BinlogPos, error) {
+		if err := exec("SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			return BinlogPos{}, err
+		}
+		if !s.conf.Snapshot.LockTables {
+			return s.startConsistentSnapshot(rail, exec)
+		}
+
+		// don't block writes for too long if the lock can't be acquired, e.g., long-running queries
+		if err := exec("SET SESSION lock_wait_timeout = " + strconv.Itoa(snapshotLockWaitTimeout)); err != nil {
+			return BinlogPos{}, err
+		}
+		if err := exec("FLUSH TABLES WITH READ LOCK"); err != nil {
+			return BinlogPos{}, err
+		}
+		pos, err := func() (BinlogPos, error) {
+			if err := exec("START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+				return BinlogPos{}, err
+			}
+			return s.initialPos(rail, startFrom{Raw: StartFromLatest})
+		}()
+		if uerr := exec("UNLOCK TABLES"); uerr != nil && err == nil {
+			err = uerr
+		}
+		return pos, err
+	}()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &snapshotSession{conn: conn, pos: pos}, nil
+}
+
+// Start the consistent snapshot transaction without locks, returns the binlog position recorded right before the transaction.
+func (s *Source) startConsistentSnapshot(rail miso.Rail, exec func(stmt string) error) (BinlogPos, error) {
+	latest := startFrom{Raw: StartFromLatest}
+	for i := 1; ; i++ {
+		before, err := s.initialPos(rail, latest)
+		if err != nil {
+			return BinlogPos{}, err
+		}
+		if err := exec("START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+			return BinlogPos{}, err
+		}
+		after, err := s.initialPos(rail, latest)
+		if err != nil {
+			return BinlogPos{}, err
+		}
+		if before == after {
+			return before, nil
+		}
+		if i >= snapshotPosAttempts {
+			rail.Infof("Transactions committed between %+v and %+v may be included in the snapshot, they are published again", before, after)
+			return before, nil
+		}
+		if err := exec("ROLLBACK"); err != nil {
+			return BinlogPos{}, err
+		}
+	}
+}
+
+func (ss *snapshotSession) close(rail miso.Rail) {
+	if _, err := ss.conn.ExecContext(context.Background(), "ROLLBACK"); err != nil {
+		rail.Warnf("Failed to end snapshot transaction, %v", err)
+	}
+	ss.conn.Close()
+}
+
+// Resolve the snapshot tables, 'schema.*' is expanded to all the base tables in the schema.
+func (s *Source) resolveSnapshotTables(rail miso.Rail) ([]snapshotTable, error) {
+	tables := []snapshotTable{}
+	seen := map[snapshotTable]bool{}
+	add := func(t snapshotTable) {
+		if !seen[t] && s.includeSchema(t.Schema) {
+			seen[t] = true
+			tables = append(tables, t)
+		}
+	}
+	for _, v := range s.conf.Snapshot.Tables {
+		t, err := parseSnapshotTable(v)
+		if err != nil {
+			return nil, err
+		}
+		if t.Table != "*" {
+			add(t)
+			continue
+		}
+		var names []string
+		err = s.conn.
+			Table("information_schema.tables").
+			Select("table_name").
+			Where("table_schema = ? AND table_type = 'BASE TABLE'", t.Schema).
+			Order("table_name asc").
+			Scan(&names).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tables of schema '%v', %w", t.Schema, err)
+		}
+		for _, n := range names {
+			add(snapshotTable{Schema: t.Schema, Table: n})
+		}
+	}
+	return tables, nil
+}
+
+// Read the table in PK order in chunks, the rows are published as SNAP events, returns the number of rows read.
+func (s *Source) snapshotTable(rail miso.Rail, sess *snapshotSession, t snapshotTable, txId string, seq int, timestamp uint32, emit snapshotEmitter) (int, error) {
+	ti, err := s.FetchTableInfo(rail, t.Schema, t.Table)
+	if err != nil {
+		return 0, err
+	}
+	if len(ti.Columns) < 1 {
+		return 0, errors.New("table not found")
+	}
+	pks, err := s.fetchPrimaryKey(t)
+	if err != nil {
+		return 0, err
+	}
+	if len(pks) < 1 {
+		return 0, errors.New("table doesn't have primary key")
+	}
+	cols := make([]string, 0, len(ti.Columns))
+	for _, c := range ti.Columns {
+		cols = append(cols, c.ColumnName)
+	}
+	pkIdx := make([]int, 0, len(pks))
+	for _, pk := range pks {
+		i := slices.Index(cols, pk)
+		if i < 0 {
+			return 0, fmt.Errorf("primary key column '%v' not found", pk)
+		}
+		pkIdx = append(pkIdx, i)
+	}
+
+	chunkSize := s.conf.Snapshot.ChunkSize
+	total := 0
+	var last []any
+	for {
+		if miso.IsShuttingDown() || rail.Context().Err() != nil {
+			return total, errSnapshotStopped
+		}
+		rows, err := readSnapshotChunk(rail, sess.conn, ti, snapshotChunkQuery(t, cols, pks, last != nil, chunkSize), last)
+		if err != nil {
+			return total, err
+		}
+		if len(rows) < 1 {
+			return total, nil
+		}
+
+		dce := DataChangeEvent{
+			Source:     s.Name,
+			Timestamp:  timestamp,
+			Schema:     t.Schema,
+			Table:      t.Table,
+			Type:       TypeSnapshot,
+			Records:    make([]Record, 0, len(rows)),
+			Columns:    newRecordColumns(ti.Columns),
+			TxId:       txId,
+			TxSeq:      seq + total,
+			BinlogFile: sess.pos.Name,
+			BinlogPos:  sess.pos.Pos,
+		}
+		for _, r := range rows {
+			dce.Records = append(dce.Records, Record{After: r})
+		}
+		if err := emit(rail, dce, TxInfo{Source: s.Name, Id: txId, Timestamp: timestamp, Records: len(rows)}); err != nil {
+			return total, err
+		}
+		total += len(rows)
+		snapshotRowsCounter.WithLabelValues(s.Name).Add(float64(len(rows)))
+
+		if len(rows) < chunkSize {
+			return total, nil
+		}
+		lastRow := rows[len(rows)-1]
+		last = make([]any, 0, len(pkIdx))
+		for _, i := range pkIdx {
+			last = append(last, lastRow[i])
+		}
+	}
+}
+
+// Columns of the primary key in the order of the index.
+func (s *Source) fetchPrimaryKey(t snapshotTable) ([]string, error) {
+	var pks []string
+	err := s.conn.
+		Table("information_schema.statistics").
+		Select("column_name").
+		Where("table_schema = ? AND table_name = ? AND index_name = 'PRIMARY'", t.Schema, t.Table).
+		Order("seq_in_index asc").
+		Scan(&pks).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch primary key, %w", err)
+	}
+	return pks, nil
+}
+
+func readSnapshotChunk(rail miso.Rail, conn *sql.Conn, ti TableInfo, query string, last []any) ([][]any, error) {
+	rows, err := conn.QueryContext(rail.Context(), query, last...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	read := [][]any{}
+	raw := make([]sql.RawBytes, len(ti.Columns))
+	dest := make([]any, len(raw))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]any, len(raw))
+		for i, v := range raw {
+			if row[i], err = snapshotValue(ti.Columns[i], v); err != nil {
+				return nil, fmt.Errorf("failed to read column '%v', %w", ti.Columns[i].ColumnName, err)
+			}
+		}
+		read = append(read, row)
+	}
+	return read, rows.Err()
+}
+
+// Build the query of the chunk, rows are read in PK order after the last PK of the previous chunk.
+func snapshotChunkQuery(t snapshotTable, cols []string, pks []string, after bool, limit int) string {
+	b := strings.Builder{}
+	b.WriteString("SELECT ")
+	for i, c := range cols {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(quoteIdent(c))
+	}
+	b.WriteString(" FROM ")
+	b.WriteString(quoteIdent(t.Schema) + "." + quoteIdent(t.Table))
+
+	quotedPks := make([]string, 0, len(pks))
+	for _, pk := range pks {
+		quotedPks = append(quotedPks, quoteIdent(pk))
+	}
+	if after {
+		b.WriteString(" WHERE (" + strings.Join(quotedPks, ", ") + ") > (")
+		b.WriteString(strings.TrimSuffix(strings.Repeat("?, ", len(pks)), ", "))
+		b.WriteString(")")
+	}
+	b.WriteString(" ORDER BY " + strings.Join(quotedPks, ", "))
+	b.WriteString(" LIMIT " + strconv.Itoa(limit))
+	return b.String()
+}
+
+func quoteIdent(v string) string {
+	return "`" + strings.ReplaceAll(v, "`", "``") + "`"
+}
+
+// Convert column value read from table to the same type as the one decoded from binlog.
+func snapshotValue(col ColumnInfo, v sql.RawBytes) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch strings.ToLower(col.DataType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		if col.Unsigned {
+			return strconv.ParseUint(string(v), 10, 64)
+		}
+		return strconv.ParseInt(string(v), 10, 64)
+	case "year":
+		return strconv.ParseInt(string(v), 10, 64)
+	case "float":
+		f, err := strconv.ParseFloat(string(v), 32)
+		return float32(f), err
+	case "double":
+		return strconv.ParseFloat(string(v), 64)
+	case "bit":
+		var n int64
+		for _, b := range v {
+			n = n<<8 | int64(b)
+		}
+		return n, nil
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "geometry":
+		return append([]byte{}, v...), nil
+	}
+	return string(v), nil
+}
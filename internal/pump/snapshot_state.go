@@ -0,0 +1,326 @@
+package pump
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util/hash"
+	"github.com/curtisnewbie/miso/util/osutil"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// Pipelines that have received the snapshot.
+//
+// A pipeline that is added after the initial snapshot (at runtime or in configuration) doesn't have the snapshot,
+// the snapshot is taken again for the pipeline only.
+//
+// The snapshot of the pipeline is taken at the latest binlog position of master, while the stream may be lagging behind,
+// the pipeline is held at the position of its snapshot, i.e., transactions before the position are not published
+// through the pipeline, until the stream passes the position.
+type snapshotState struct {
+	mu        sync.Mutex
+	flushMu   sync.Mutex // serialize flushes, the older state never overwrites the newer one
+	loaded    bool       // whether the state is loaded from storage or initialized by snapshot
+	pipelines map[string]bool
+	holds     map[string]BinlogPos // binlog position of the snapshot of the pipeline that is not yet passed by the stream
+	dirty     bool
+}
+
+type snapshotStateFile struct {
+	Pipelines []string             `json:"pipelines"`
+	Holds     map[string]BinlogPos `json:"holds,omitempty"`
+}
+
+func newSnapshotState() *snapshotState {
+	return &snapshotState{pipelines: map[string]bool{}, holds: map[string]BinlogPos{}}
+}
+
+func (st *snapshotState) isLoaded() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.loaded
+}
+
+func (st *snapshotState) contains(key string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.pipelines[key]
+}
+
+// Replace the snapshotted pipelines.
+func (st *snapshotState) reset(keys []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pipelines = map[string]bool{}
+	st.holds = map[string]BinlogPos{}
+	for _, k := range keys {
+		st.pipelines[k] = true
+	}
+	st.loaded = true
+	st.dirty = true
+}
+
+func (st *snapshotState) add(keys []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, k := range keys {
+		st.pipelines[k] = true
+	}
+	st.dirty = true
+}
+
+// Hold the pipelines at the binlog position of their snapshot.
+func (st *snapshotState) hold(keys []string, pos BinlogPos) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, k := range keys {
+		st.holds[k] = pos
+	}
+	st.dirty = true
+}
+
+func (st *snapshotState) copyHolds() map[string]BinlogPos {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.holds) < 1 {
+		return nil
+	}
+	cp := make(map[string]BinlogPos, len(st.holds))
+	for k, v := range st.holds {
+		cp[k] = v
+	}
+	return cp
+}
+
+// Release the holds that are passed.
+func (st *snapshotState) release(passed func(pos BinlogPos) bool) []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	released := []string{}
+	for k, pos := range st.holds {
+		if passed(pos) {
+			delete(st.holds, k)
+			released = append(released, k)
+			st.dirty = true
+		}
+	}
+	return released
+}
+
+// Forget the pipelines that are removed, the snapshot is taken again if they are added back.
+func (st *snapshotState) retain(keys []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for k := range st.pipelines {
+		if !slices.Contains(keys, k) {
+			delete(st.pipelines, k)
+			st.dirty = true
+		}
+	}
+	for k := range st.holds {
+		if !slices.Contains(keys, k) {
+			delete(st.holds, k)
+			st.dirty = true
+		}
+	}
+}
+
+func (st *snapshotState) marshalIfDirty() ([]byte, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.dirty {
+		return nil, false, nil
+	}
+	f := snapshotStateFile{Pipelines: make([]string, 0, len(st.pipelines)), Holds: st.holds}
+	for k := range st.pipelines {
+		f.Pipelines = append(f.Pipelines, k)
+	}
+	slices.Sort(f.Pipelines)
+	buf, err := json.Marshal(f)
+	if err != nil {
+		return nil, false, err
+	}
+	st.dirty = false
+	return buf, true, nil
+}
+
+func (st *snapshotState) load(buf []byte) error {
+	var f snapshotStateFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return err
+	}
+	st.reset(f.Pipelines)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for k, pos := range f.Holds {
+		st.holds[k] = pos
+	}
+	st.dirty = false
+	return nil
+}
+
+// Key of the pipeline in snapshot state.
+func pipelineSnapshotKey(p Pipeline) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", p.Source, p.Schema, p.Table, p.Type, p.Stream)
+}
+
+func pipelineSnapshotKeys(pipelines []Pipeline) []string {
+	keys := make([]string, 0, len(pipelines))
+	for _, p := range pipelines {
+		keys = append(keys, pipelineSnapshotKey(p))
+	}
+	return keys
+}
+
+// Pipelines that subscribe events of the source.
+func (s *Source) pipelines() []Pipeline {
+	pipelines := []Pipeline{}
+	for _, p := range copyPipelines() {
+		if p.Source == "" || p.Source == s.Name {
+			pipelines = append(pipelines, p)
+		}
+	}
+	return pipelines
+}
+
+// Request snapshot for the pipelines that haven't received the snapshot yet, it's taken between transactions.
+func (s *Source) requestSnapshot() {
+	if s.conf.Snapshot.Enabled {
+		s.snapshotRequested.Store(true)
+	}
+}
+
+func (s *Source) LoadSnapshotState(rail miso.Rail) error {
+	if !s.conf.Snapshot.Enabled {
+		return nil
+	}
+	buf, err := s.doReadSnapStateFunc(rail)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot state, %w", err)
+	}
+	if len(buf) < 1 {
+		return nil
+	}
+	if err := s.snapState.load(buf); err != nil {
+		return fmt.Errorf("failed to parse snapshot state, %w", err)
+	}
+	rail.Infof("Loaded snapshot state of source '%v', %d pipelines", s.Name, len(s.snapState.pipelines))
+	return nil
+}
+
+func (s *Source) FlushSnapshotState() error {
+	s.snapState.flushMu.Lock()
+	defer s.snapState.flushMu.Unlock()
+
+	buf, ok, err := s.snapState.marshalIfDirty()
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot state, %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	if err := s.doFlushSnapStateFunc(buf); err != nil {
+		s.snapState.mu.Lock()
+		s.snapState.dirty = true
+		s.snapState.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Handlers of the pipelines that have received the changes of the current transaction in snapshot,
+// i.e., the pipelines are held at the position of their snapshot, and the transaction is before the position.
+func (s *Source) snapshottedHandlers(rail miso.Rail) hash.Set[string] {
+	if s.currTx.snapshotted != nil {
+		return *s.currTx.snapshotted
+	}
+	snapshotted := hash.NewSet[string]()
+	if holds := s.snapState.copyHolds(); len(holds) > 0 {
+		for _, p := range s.pipelines() {
+			if pos, ok := holds[pipelineSnapshotKey(p)]; ok && s.txInSnapshot(rail, pos) {
+				snapshotted.Add(p.HandlerId)
+			}
+		}
+	}
+	if s.currTx.Active {
+		s.currTx.snapshotted = &snapshotted
+	}
+	return snapshotted
+}
+
+// Whether the current transaction is before the binlog position of the snapshot, i.e., its changes are included in the snapshot.
+//
+// GTID is compared if available, binlog positions are only comparable on the same server.
+func (s *Source) txInSnapshot(rail miso.Rail, pos BinlogPos) bool {
+	if pos.GTID != "" && s.currTx.GTID != "" {
+		snap, err := mysql.ParseGTIDSet(s.flavor(), pos.GTID)
+		if err == nil {
+			var tx mysql.GTIDSet
+			if tx, err = mysql.ParseGTIDSet(s.flavor(), s.currTx.GTID); err == nil {
+				return snap.Contain(tx)
+			}
+		}
+		rail.Warnf("Failed to compare GTID of transaction '%v' with snapshot '%v', comparing binlog position instead, %v", s.currTx.GTID, pos.GTID, err)
+	}
+	return s.currTx.Pos.Compare(pos.Position) < 0
+}
+
+// Release the holds of pipelines that are passed by the flushed binlog position, it's called after the position is flushed.
+//
+// The holds are only released once the position is persisted, if the stream is restarted from an older position, the pipelines are still held.
+func (s *Source) releaseSnapshotHolds() {
+	if !s.conf.Snapshot.Enabled {
+		return
+	}
+	curr := s.currPos
+	released := s.snapState.release(func(pos BinlogPos) bool {
+		if pos.GTID != "" && curr.GTID != "" {
+			snap, err := mysql.ParseGTIDSet(s.flavor(), pos.GTID)
+			if err != nil {
+				return false
+			}
+			flushed, err := mysql.ParseGTIDSet(s.flavor(), curr.GTID)
+			return err == nil && flushed.Contain(snap)
+		}
+		return pos.Position.Compare(curr.Position) <= 0
+	})
+	if len(released) < 1 {
+		return
+	}
+	miso.Infof("Stream of source '%v' passed the snapshot position of pipelines: %v", s.Name, released)
+	if err := s.FlushSnapshotState(); err != nil {
+		miso.Errorf("failed to flush snapshot state of source '%v', %v", s.Name, err)
+	}
+}
+
+func (s *Source) readLocalSnapStateFile(rail miso.Rail) ([]byte, error) {
+	f := s.conf.Snapshot.StateFile
+	if f == "" {
+		return nil, nil
+	}
+	ok, err := osutil.FileExists(f)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return osutil.ReadFileAll(f)
+}
+
+func (s *Source) flushLocalSnapStateFile(byt []byte) error {
+	f := s.conf.Snapshot.StateFile
+	if f == "" {
+		return nil
+	}
+	return overwriteLocalFile(f, "snapshot state", byt)
+}
+
+func (s *Source) flushZkSnapStateFile(byt []byte) error {
+	return ZkWrite(s.zkPath(ZkPathSnapshotState), byt)
+}
+
+func (s *Source) readZkSnapStateFile(rail miso.Rail) ([]byte, error) {
+	return ZkRead(s.zkPath(ZkPathSnapshotState))
+}
@@ -0,0 +1,360 @@
+package pump
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestParseSnapshotTable(t *testing.T) {
+	tab := []struct {
+		v        string
+		expected snapshotTable
+		ok       bool
+	}{
+		{"my_db.my_table", snapshotTable{Schema: "my_db", Table: "my_table"}, true},
+		{" my_db.* ", snapshotTable{Schema: "my_db", Table: "*"}, true},
+		{"my_table", snapshotTable{}, false},
+		{"my_db.", snapshotTable{}, false},
+		{".my_table", snapshotTable{}, false},
+	}
+	for _, v := range tab {
+		actual, err := parseSnapshotTable(v.v)
+		if (err == nil) != v.ok {
+			t.Fatalf("v: '%v', err: %v", v.v, err)
+		}
+		if actual != v.expected {
+			t.Fatalf("v: '%v', expected: %+v, actual: %+v", v.v, v.expected, actual)
+		}
+	}
+}
+
+func TestSnapshotChunkQuery(t *testing.T) {
+	tb := snapshotTable{Schema: "my_db", Table: "my`table"}
+	q := snapshotChunkQuery(tb, []string{"id", "name", "seq"}, []string{"id", "seq"}, false, 100)
+	if q != "SELECT `id`, `name`, `seq` FROM `my_db`.`my``table` ORDER BY `id`, `seq` LIMIT 100" {
+		t.Fatal(q)
+	}
+	q = snapshotChunkQuery(tb, []string{"id", "name", "seq"}, []string{"id", "seq"}, true, 100)
+	if q != "SELECT `id`, `name`, `seq` FROM `my_db`.`my``table` WHERE (`id`, `seq`) > (?, ?) ORDER BY `id`, `seq` LIMIT 100" {
+		t.Fatal(q)
+	}
+}
+
+func TestSnapshotValue(t *testing.T) {
+	tab := []struct {
+		col      ColumnInfo
+		v        sql.RawBytes
+		expected any
+	}{
+		{ColumnInfo{DataType: "int"}, sql.RawBytes("-1"), int64(-1)},
+		{ColumnInfo{DataType: "bigint", Unsigned: true}, sql.RawBytes("18446744073709551615"), uint64(18446744073709551615)},
+		{ColumnInfo{DataType: "double"}, sql.RawBytes("1.5"), float64(1.5)},
+		{ColumnInfo{DataType: "float"}, sql.RawBytes("1.5"), float32(1.5)},
+		{ColumnInfo{DataType: "bit"}, sql.RawBytes{0x01, 0x02}, int64(258)},
+		{ColumnInfo{DataType: "decimal"}, sql.RawBytes("12.50"), "12.50"},
+		{ColumnInfo{DataType: "datetime"}, sql.RawBytes("2024-01-02 15:04:05"), "2024-01-02 15:04:05"},
+		{ColumnInfo{DataType: "varbinary"}, sql.RawBytes{0xff}, []byte{0xff}},
+		{ColumnInfo{DataType: "varchar"}, sql.RawBytes(""), ""},
+		{ColumnInfo{DataType: "varchar"}, nil, nil},
+	}
+	for _, v := range tab {
+		actual, err := snapshotValue(v.col, v.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, v.expected) {
+			t.Fatalf("col: %+v, expected: %#v, actual: %#v", v.col, v.expected, actual)
+		}
+	}
+	if _, err := snapshotValue(ColumnInfo{DataType: "int"}, sql.RawBytes("abc")); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestSnapshotConfig(t *testing.T) {
+	conf := SourceConfig{Name: "test", Flavor: flavorMysql, Snapshot: SnapshotConfig{Enabled: true}}
+	if _, err := NewSource(conf); err == nil {
+		t.Fatal("snapshot tables are missing, should fail")
+	}
+	conf.Snapshot.Tables = []string{"my_table"}
+	if _, err := NewSource(conf); err == nil {
+		t.Fatal("snapshot table is invalid, should fail")
+	}
+	conf.Snapshot.Tables = []string{"my_db.my_table"}
+	s, err := NewSource(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.conf.Snapshot.ChunkSize != defaultSnapshotChunkSize {
+		t.Fatal(s.conf.Snapshot.ChunkSize)
+	}
+	conf.StartFrom = StartFromEarliest
+	if _, err := NewSource(conf); err == nil {
+		t.Fatal("start-from is not supported with snapshot, should fail")
+	}
+
+	conf = SourceConfig{Name: "test", Flavor: flavorMysql, PurgedPosPolicy: PurgedPosPolicySnapshot}
+	if _, err := NewSource(conf); err == nil {
+		t.Fatal("snapshot tables are missing, should fail")
+	}
+	conf.Snapshot.Tables = []string{"my_db.*"}
+	if _, err := NewSource(conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotEventId(t *testing.T) {
+	dce := DataChangeEvent{
+		Source:     "test",
+		Schema:     "my_db",
+		Table:      "my_table",
+		Type:       TypeSnapshot,
+		Columns:    []RecordColumn{{Name: "id", DataType: "bigint", PrimaryKey: true}},
+		Records:    []Record{{After: []any{int64(1)}}, {After: []any{int64(2)}}},
+		TxId:       "snapshot:binlog.000001:4",
+		BinlogFile: "binlog.000001",
+		BinlogPos:  4,
+	}
+	first, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dce.TxSeq = 2
+	dce.Records = []Record{{After: []any{int64(3)}}}
+	second, err := streamEventMapper{}.MapEvent(dce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := second[0].(StreamEvent)
+	if ev.Type != TypeSnapshot || ev.TxSeq != 2 || ev.Key["id"] != "3" || ev.Columns["id"].After != "3" {
+		t.Fatalf("%+v", ev)
+	}
+	ids := map[string]bool{}
+	for _, e := range append(first, second...) {
+		ids[e.(StreamEvent).EventId] = true
+	}
+	if len(ids) != 3 {
+		t.Fatalf("event id should be unique within the snapshot, %v", ids)
+	}
+}
+
+func TestSnapshotState(t *testing.T) {
+	st := newSnapshotState()
+	if st.isLoaded() {
+		t.Fatal("should not be loaded")
+	}
+	st.reset([]string{"a", "b"})
+	st.add([]string{"c"})
+	st.retain([]string{"a", "c", "d"})
+	buf, ok, err := st.marshalIfDirty()
+	if err != nil || !ok {
+		t.Fatal(err)
+	}
+	if string(buf) != `{"pipelines":["a","c"]}` {
+		t.Fatal(string(buf))
+	}
+	if _, ok, _ := st.marshalIfDirty(); ok {
+		t.Fatal("should not be dirty")
+	}
+
+	st = newSnapshotState()
+	if err := st.load(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !st.isLoaded() || !st.contains("a") || st.contains("b") || !st.contains("c") {
+		t.Fatalf("%+v", st.pipelines)
+	}
+}
+
+func TestSnapshotPendingPipelines(t *testing.T) {
+	conf := SourceConfig{Name: "test-snapshot", Flavor: flavorMysql, Snapshot: SnapshotConfig{Enabled: true, Tables: []string{"my_db.my_table"}}}
+	s, err := NewSource(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flushed []string
+	s.doFlushSnapStateFunc = func(byt []byte) error {
+		flushed = append(flushed, string(byt))
+		return nil
+	}
+
+	rail := miso.EmptyRail()
+	p1 := Pipeline{Schema: "^my_db$", Table: "^my_table$", Stream: "test-snapshot-1", Source: "test-snapshot", Enabled: true}
+	p2 := Pipeline{Schema: "^other_db$", Table: ".*", Stream: "test-snapshot-2", Source: "test-snapshot", Enabled: true}
+	if err := RegisterSources(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPipeline(rail, p1); err != nil {
+		t.Fatal(err)
+	}
+	defer RemovePipeline(rail, p1)
+	if !s.snapshotRequested.Load() {
+		t.Fatal("snapshot should be requested when pipeline is added")
+	}
+
+	// snapshot state is missing, existing pipelines are considered snapshotted
+	if err := s.snapshotPendingPipelines(rail); err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) != 1 || flushed[0] != `{"pipelines":["test-snapshot|^my_db$|^my_table$||test-snapshot-1"]}` {
+		t.Fatalf("%v", flushed)
+	}
+
+	// pipeline added, but it doesn't match any of the snapshot tables, nothing to read
+	if err := AddPipeline(rail, p2); err != nil {
+		t.Fatal(err)
+	}
+	defer RemovePipeline(rail, p2)
+	if err := s.snapshotPendingPipelines(rail); err != nil {
+		t.Fatal(err)
+	}
+	if !s.snapState.contains(pipelineSnapshotKey(p2)) {
+		t.Fatalf("%+v", s.snapState.pipelines)
+	}
+
+	// pipeline removed
+	RemovePipeline(rail, p1)
+	if err := s.snapshotPendingPipelines(rail); err != nil {
+		t.Fatal(err)
+	}
+	if s.snapState.contains(pipelineSnapshotKey(p1)) || !slices.Equal(flushed[len(flushed)-1:], []string{`{"pipelines":["test-snapshot|^other_db$|.*||test-snapshot-2"]}`}) {
+		t.Fatalf("%v", flushed)
+	}
+}
+
+func TestSnapshotPendingPipelinesStreamBehind(t *testing.T) {
+	var mu sync.Mutex
+	published := []string{}
+	defer func(f func(miso.Rail, any, string) error) { pubEventBus = f }(pubEventBus)
+	pubEventBus = func(rail miso.Rail, eventObject any, name string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, fmt.Sprintf("%+v", eventObject))
+		return nil
+	}
+
+	conf := SourceConfig{Name: "test-snapshot-behind", Flavor: flavorMysql, Snapshot: SnapshotConfig{Enabled: true, Tables: []string{"my_db.my_table"}}}
+	s, err := NewSource(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterSources(s); err != nil {
+		t.Fatal(err)
+	}
+	var flushed []string
+	s.doFlushSnapStateFunc = func(byt []byte) error {
+		flushed = append(flushed, string(byt))
+		return nil
+	}
+	s.doFlushPosFunc = func(byt []byte) error { return nil }
+	s.snapState.reset(nil)
+	s.nextPos = BinlogPos{Position: mysql.Position{Name: "binlog.000001", Pos: 4}}
+
+	// snapshot is taken at the latest position of master, the stream is still at binlog.000001:4
+	snapshotPos := BinlogPos{Position: mysql.Position{Name: "binlog.000001", Pos: 1000}}
+	s.doSnapshotFunc = func(rail miso.Rail, tables []snapshotTable, emitter func(t snapshotTable) snapshotEmitter) (BinlogPos, error) {
+		dce := DataChangeEvent{Source: s.Name, Schema: "my_db", Table: "my_table", Type: TypeSnapshot, TxId: "snapshot:binlog.000001:1000",
+			Columns: []RecordColumn{{Name: "id", DataType: "bigint"}, {Name: "name", DataType: "varchar"}},
+			Records: []Record{{After: []any{int64(1), "snapshot"}}}}
+		return snapshotPos, emitter(tables[0])(rail, dce, TxInfo{Source: s.Name, Id: dce.TxId, Records: 1})
+	}
+
+	rail := miso.EmptyRail()
+	p := Pipeline{Schema: "^my_db$", Table: "^my_table$", Stream: "test-snapshot-behind", Source: "test-snapshot-behind", Enabled: true}
+	if err := AddPipeline(rail, p); err != nil {
+		t.Fatal(err)
+	}
+	defer RemovePipeline(rail, p)
+	if err := s.snapshotPendingPipelines(rail); err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) < 1 || !strings.Contains(flushed[len(flushed)-1], `"holds":{"test-snapshot-behind|^my_db$|^my_table$||test-snapshot-behind":{"Name":"binlog.000001","Pos":1000}}`) {
+		t.Fatalf("%v", flushed)
+	}
+
+	ev := func(typ replication.EventType, e replication.Event, logPos uint32, size uint32) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: typ, LogPos: logPos, EventSize: size}, Event: e}
+	}
+	tme := &replication.TableMapEvent{Schema: []byte("my_db"), Table: []byte("my_table"), ColumnCount: 2,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VARCHAR}, ColumnMeta: []uint16{0, 40},
+		ColumnName: [][]byte{[]byte("id"), []byte("name")}, PrimaryKey: []uint64{0}}
+	tx := func(begin uint32, name string) {
+		events := []*replication.BinlogEvent{
+			ev(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("BEGIN")}, begin+20, 20),
+			ev(replication.TABLE_MAP_EVENT, tme, begin+70, 50),
+			ev(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{Table: tme, Rows: [][]any{{int64(1), name}}}, begin+120, 50),
+			ev(replication.XID_EVENT, &replication.XIDEvent{}, begin+150, 30),
+		}
+		for _, e := range events {
+			if err := s.handleEvent(rail, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// changes before the snapshot position are already included in the snapshot
+	tx(200, "before-snapshot")
+	s.FlushPos()
+	if len(s.snapState.copyHolds()) != 1 {
+		t.Fatal("position of the snapshot is not passed yet")
+	}
+	tx(1000, "after-snapshot")
+	s.FlushPos()
+	if len(s.snapState.copyHolds()) != 0 || strings.Contains(flushed[len(flushed)-1], "holds") {
+		t.Fatalf("hold should be released, %v", flushed)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		mu.Lock()
+		n := len(published)
+		mu.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	joined := strings.Join(published, "\n")
+	if len(published) != 2 || !strings.Contains(joined, "snapshot") || !strings.Contains(joined, "after-snapshot") || strings.Contains(joined, "before-snapshot") {
+		t.Fatalf("%v", published)
+	}
+}
+
+func TestTxInSnapshot(t *testing.T) {
+	s := newTestSource(t, flavorMysql)
+	rail := miso.EmptyRail()
+	snap := BinlogPos{Position: mysql.Position{Name: "binlog.000002", Pos: 1000}, GTID: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-20"}
+	tab := []struct {
+		gtid     string
+		pos      mysql.Position
+		expected bool
+	}{
+		{"", mysql.Position{Name: "binlog.000001", Pos: 5000}, true},
+		{"", mysql.Position{Name: "binlog.000002", Pos: 999}, true},
+		{"", mysql.Position{Name: "binlog.000002", Pos: 1000}, false},
+		{"3e11fa47-71ca-11e1-9e33-c80aa9429562:20", mysql.Position{Name: "binlog.000009", Pos: 4}, true}, // GTID is compared, e.g., after failover
+		{"3e11fa47-71ca-11e1-9e33-c80aa9429562:21", mysql.Position{Name: "binlog.000001", Pos: 4}, false},
+	}
+	for _, c := range tab {
+		s.currTx.begin(c.gtid, c.pos, 0)
+		s.currTx.GTID = c.gtid
+		if actual := s.txInSnapshot(rail, snap); actual != c.expected {
+			t.Fatalf("gtid: %v, pos: %v, expected: %v, actual: %v", c.gtid, c.pos, c.expected, actual)
+		}
+	}
+}
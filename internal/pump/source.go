@@ -0,0 +1,361 @@
+package pump
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"gorm.io/gorm"
+)
+
+const (
+	PropSyncEnabled                 = "sync.enabled"
+	PropSyncName                    = "sync.name"
+	PropSyncShutdownOnSourceFailure = "sync.shutdown-on-source-failure"
+
+	DefaultSourceName = "default"
+)
+
+func init() {
+	miso.SetDefProp(PropSyncEnabled, true)
+	miso.SetDefProp(PropSyncName, DefaultSourceName)
+	miso.SetDefProp(PropSyncShutdownOnSourceFailure, false)
+}
+
+var (
+	sources   []*Source
+	sourcesMu sync.RWMutex
+)
+
+// Configuration of a MySQL source.
+//
+// The default source is configured using `sync.*` properties, additional sources are configured using `source` list.
+type SourceConfig struct {
+	Name              string
+	ServerId          int `mapstructure:"server-id"`
+	Host              string
+	Port              int
+	User              string
+	Password          string
+	Flavor            string
+	MaxReconnect      int    `mapstructure:"max-reconnect"`
+	PosFile           string `mapstructure:"pos-file"`
+	SchemaHistoryFile string `mapstructure:"schema-history-file"`
+	GTIDEnabled       bool   `mapstructure:"gtid-enabled"`
+	StartFrom         string `mapstructure:"start-from"`
+	PurgedPosPolicy   string `mapstructure:"purged-pos-policy"`
+	Snapshot          SnapshotConfig
+	TLS               TLSConfig
+	Filter            GlobalFilter
+}
+
+type TLSConfig struct {
+	Enabled    bool
+	CAFile     string `mapstructure:"ca-file"`
+	CertFile   string `mapstructure:"cert-file"`
+	KeyFile    string `mapstructure:"key-file"`
+	ServerName string `mapstructure:"server-name"`
+	SkipVerify bool   `mapstructure:"skip-verify"`
+}
+
+// Build SourceConfig of the default source using `sync.*` properties.
+func defaultSourceConfig(filter GlobalFilter) SourceConfig {
+	return SourceConfig{
+		Name:              miso.GetPropStrTrimmed(PropSyncName),
+		ServerId:          miso.GetPropInt(PropSyncServerId),
+		Host:              miso.GetPropStr(PropSyncHost),
+		Port:              miso.GetPropInt(PropSyncPort),
+		User:              miso.GetPropStr(PropSyncUser),
+		Password:          miso.GetPropStr(PropSyncPassword),
+		Flavor:            miso.GetPropStrTrimmed(PropSyncFlavor),
+		MaxReconnect:      miso.GetPropInt(PropSyncMaxReconnect),
+		PosFile:           miso.GetPropStr(PropSyncPosFile),
+		SchemaHistoryFile: miso.GetPropStr(PropSyncSchemaHistoryFile),
+		GTIDEnabled:       miso.GetPropBool(PropSyncGTIDEnabled),
+		StartFrom:         miso.GetPropStrTrimmed(PropSyncStartFrom),
+		PurgedPosPolicy:   miso.GetPropStrTrimmed(PropSyncPurgedPosPolicy),
+		Snapshot: SnapshotConfig{
+			Enabled:    miso.GetPropBool(PropSyncSnapshotEnabled),
+			Tables:     miso.GetPropStrSlice(PropSyncSnapshotTables),
+			ChunkSize:  miso.GetPropInt(PropSyncSnapshotChunkSize),
+			StateFile:  miso.GetPropStr(PropSyncSnapshotStateFile),
+			LockTables: miso.GetPropBool(PropSyncSnapshotLockTables),
+		},
+		TLS: TLSConfig{
+			Enabled:    miso.GetPropBool(PropSyncTLSEnabled),
+			CAFile:     miso.GetPropStrTrimmed(PropSyncTLSCAFile),
+			CertFile:   miso.GetPropStrTrimmed(PropSyncTLSCertFile),
+			KeyFile:    miso.GetPropStrTrimmed(PropSyncTLSKeyFile),
+			ServerName: miso.GetPropStrTrimmed(PropSyncTLSServerName),
+			SkipVerify: miso.GetPropBool(PropSyncTLSSkipVerify),
+		},
+		Filter: filter,
+	}
+}
+
+// Fill missing fields of the additional source.
+func (c SourceConfig) withDefaults(filter GlobalFilter) SourceConfig {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Flavor = strings.TrimSpace(c.Flavor)
+	if c.ServerId == 0 {
+		c.ServerId = miso.GetPropInt(PropSyncServerId)
+	}
+	if c.Host == "" {
+		c.Host = "127.0.0.1"
+	}
+	if c.Port == 0 {
+		c.Port = 3306
+	}
+	if c.User == "" {
+		c.User = "root"
+	}
+	if c.Flavor == "" {
+		c.Flavor = flavorMysql
+	}
+	if c.MaxReconnect == 0 {
+		c.MaxReconnect = miso.GetPropInt(PropSyncMaxReconnect)
+	}
+	if c.PosFile == "" {
+		c.PosFile = "binlog_pos_" + c.Name
+	}
+	if c.SchemaHistoryFile == "" {
+		c.SchemaHistoryFile = "binlog_schema_history_" + c.Name
+	}
+	if c.Snapshot.StateFile == "" {
+		c.Snapshot.StateFile = "binlog_snapshot_state_" + c.Name
+	}
+	if c.StartFrom == "" {
+		c.StartFrom = StartFromLatest
+	}
+	if c.Filter.Include == "" && c.Filter.Exclude == "" {
+		c.Filter = filter
+	}
+	return c
+}
+
+// MySQL source, each source has its own syncer, binlog position, schema cache and filters.
+type Source struct {
+	Name string
+	conf SourceConfig
+
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+
+	conn       *gorm.DB
+	syncer     *replication.BinlogSyncer
+	syncerConf replication.BinlogSyncerConfig
+	streamer   *replication.BinlogStreamer
+
+	// version of the master instance, detected when connected.
+	serverVersion string
+
+	startFrom startFrom
+
+	currPos            BinlogPos
+	nextPos            BinlogPos
+	lastBinlogTime     time.Time // last time binlog event (including heartbeat) received
+	lastBinlogWarnTime time.Time
+	lag                time.Duration // replication lag of the last binlog event
+	posMu              sync.RWMutex
+
+	// posFile is flushed in every 1s (at most)
+	updatePosFileTicker *miso.TickRunner
+	posFile             *os.File
+
+	tableInfoMap map[string]TableInfo
+	schemaHist   *schemaHistory
+
+	// pipelines that have received the snapshot, only maintained when snapshot is enabled.
+	snapState *snapshotState
+
+	// whether snapshot of the pending pipelines should be taken, checked between transactions.
+	snapshotRequested atomic.Bool
+
+	currTx txState
+
+	// index of the event being handled within the compressed transaction payload, 0 if it's not in a payload.
+	payloadIdx int
+
+	// executed GTID set, only maintained in GTID mode.
+	gtidSet mysql.GTIDSet
+
+	// GTID of the transaction that is not yet committed.
+	pendingGTID mysql.GTIDSet
+
+	resyncErrCount int32
+
+	// stream of the source is stopped because of error, other sources keep running.
+	failed atomic.Bool
+
+	doAttachPosFunc       func(rail miso.Rail) error
+	doDetachPosFunc       func(rail miso.Rail)
+	doFlushPosFunc        func(byt []byte) error
+	doReadPosFunc         func(rail miso.Rail) ([]byte, error)
+	doFlushSchemaHistFunc func(byt []byte) error
+	doReadSchemaHistFunc  func(rail miso.Rail) ([]byte, error)
+	doFlushSnapStateFunc  func(byt []byte) error
+	doReadSnapStateFunc   func(rail miso.Rail) ([]byte, error)
+	doStartStreamFunc     func(rail miso.Rail) error
+	doSnapshotFunc        func(rail miso.Rail, tables []snapshotTable, emitter func(t snapshotTable) snapshotEmitter) (BinlogPos, error)
+}
+
+func NewSource(conf SourceConfig) (*Source, error) {
+	if conf.Name == "" {
+		return nil, fmt.Errorf("source name is empty")
+	}
+	if !strings.EqualFold(conf.Flavor, flavorMysql) && !strings.EqualFold(conf.Flavor, flavorMariaDB) {
+		return nil, fmt.Errorf("invalid flavor of source '%v': '%v', only '%v' and '%v' are supported", conf.Name, conf.Flavor, flavorMysql, flavorMariaDB)
+	}
+
+	conf.PurgedPosPolicy = strings.ToLower(strings.TrimSpace(conf.PurgedPosPolicy))
+	if conf.PurgedPosPolicy == "" {
+		conf.PurgedPosPolicy = PurgedPosPolicyFail
+	}
+	if conf.Snapshot.ChunkSize < 1 {
+		conf.Snapshot.ChunkSize = defaultSnapshotChunkSize
+	}
+
+	s := &Source{
+		Name:         conf.Name,
+		conf:         conf,
+		tableInfoMap: make(map[string]TableInfo),
+		schemaHist:   newSchemaHistory(),
+		snapState:    newSnapshotState(),
+	}
+	sf, err := parseStartFrom(conf.StartFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start-from of source '%v', %w", conf.Name, err)
+	}
+	if s.isGTIDMode() && (sf.Pos.Name != "" || !sf.Time.IsZero()) {
+		return nil, fmt.Errorf("invalid start-from of source '%v': '%v', only '%v' and '%v' are supported in GTID mode",
+			conf.Name, conf.StartFrom, StartFromEarliest, StartFromLatest)
+	}
+	s.startFrom = sf
+
+	if !validPurgedPosPolicy(conf.PurgedPosPolicy) {
+		return nil, fmt.Errorf("invalid purged-pos-policy of source '%v': '%v', only '%v', '%v', '%v' and '%v' are supported",
+			conf.Name, conf.PurgedPosPolicy, PurgedPosPolicyFail, PurgedPosPolicyEarliest, PurgedPosPolicyLatest, PurgedPosPolicySnapshot)
+	}
+
+	if conf.Snapshot.Enabled || conf.PurgedPosPolicy == PurgedPosPolicySnapshot {
+		if len(conf.Snapshot.Tables) < 1 {
+			return nil, fmt.Errorf("snapshot of source '%v' is enabled, but snapshot tables are not configured", conf.Name)
+		}
+		for _, t := range conf.Snapshot.Tables {
+			if _, err := parseSnapshotTable(t); err != nil {
+				return nil, fmt.Errorf("invalid snapshot tables of source '%v', %w", conf.Name, err)
+			}
+		}
+	}
+	if conf.Snapshot.Enabled && sf.Raw != StartFromLatest {
+		return nil, fmt.Errorf("invalid start-from of source '%v': '%v', snapshot always resumes streaming from the latest position", conf.Name, conf.StartFrom)
+	}
+
+	if conf.Filter.Include != "" {
+		r, err := regexp.Compile(conf.Filter.Include)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include filter of source '%v', %w", conf.Name, err)
+		}
+		s.include = r
+	}
+	if conf.Filter.Exclude != "" {
+		r, err := regexp.Compile(conf.Filter.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude filter of source '%v', %w", conf.Name, err)
+		}
+		s.exclude = r
+	}
+	s.updatePosFileTicker = miso.NewTickRuner(time.Millisecond*1000, s.FlushPos)
+	s.SetupPosFileStorage(false)
+	s.doStartStreamFunc = s.startStream
+	s.doSnapshotFunc = s.snapshot
+	return s, nil
+}
+
+// Register sources, the name of each source must be unique.
+func RegisterSources(ss ...*Source) error {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	for _, s := range ss {
+		for _, prev := range sources {
+			if prev.Name == s.Name {
+				return fmt.Errorf("duplicate source name: '%v'", s.Name)
+			}
+		}
+		sources = append(sources, s)
+	}
+	return nil
+}
+
+func Sources() []*Source {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	cp := make([]*Source, len(sources))
+	copy(cp, sources)
+	return cp
+}
+
+func FindSource(name string) (*Source, bool) {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	for _, s := range sources {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Load sources from configuration.
+func LoadSources(config EventPumpConfig) ([]*Source, error) {
+	confs := []SourceConfig{}
+	if miso.GetPropBool(PropSyncEnabled) {
+		confs = append(confs, defaultSourceConfig(config.Filter))
+	}
+	for _, c := range config.Sources {
+		confs = append(confs, c.withDefaults(config.Filter))
+	}
+
+	ss := make([]*Source, 0, len(confs))
+	for _, c := range confs {
+		s, err := NewSource(c)
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+func (s *Source) isGTIDMode() bool {
+	return s.conf.GTIDEnabled
+}
+
+func (s *Source) flavor() string {
+	if strings.EqualFold(s.conf.Flavor, flavorMariaDB) {
+		return flavorMariaDB
+	}
+	return flavorMysql
+}
+
+func (s *Source) includeSchema(schema string) bool {
+	if s.exclude != nil && s.exclude.MatchString(schema) { // exclude specified and matched
+		return false
+	}
+	if s.include != nil && !s.include.MatchString(schema) { // include specified, but doesn't match
+		return false
+	}
+	return true
+}
+
+func (s *Source) isDefault() bool {
+	return s.Name == miso.GetPropStrTrimmed(PropSyncName) && miso.GetPropBool(PropSyncEnabled)
+}
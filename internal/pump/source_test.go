@@ -0,0 +1,36 @@
+package pump
+
+import (
+	"testing"
+)
+
+func TestSourceConfigWithDefaults(t *testing.T) {
+	global := GlobalFilter{Include: "^my_db$"}
+	c := SourceConfig{Name: " cluster-b ", Host: "10.0.0.2"}.withDefaults(global)
+	if c.Name != "cluster-b" || c.Port != 3306 || c.User != "root" || c.Flavor != flavorMysql {
+		t.Fatalf("%+v", c)
+	}
+	if c.PosFile != "binlog_pos_cluster-b" || c.SchemaHistoryFile != "binlog_schema_history_cluster-b" {
+		t.Fatalf("%+v", c)
+	}
+	if c.Filter != global {
+		t.Fatalf("global filter should be used, %+v", c.Filter)
+	}
+
+	c = SourceConfig{Name: "cluster-c", Filter: GlobalFilter{Exclude: "^test$"}}.withDefaults(global)
+	if c.Filter.Include != "" || c.Filter.Exclude != "^test$" {
+		t.Fatalf("source filter should be used, %+v", c.Filter)
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	if _, err := NewSource(SourceConfig{Name: "", Flavor: flavorMysql}); err == nil {
+		t.Fatal("source name should be required")
+	}
+	if _, err := NewSource(SourceConfig{Name: "test", Flavor: "oracle"}); err == nil {
+		t.Fatal("flavor should be validated")
+	}
+	if _, err := NewSource(SourceConfig{Name: "test", Flavor: flavorMysql, Filter: GlobalFilter{Include: "("}}); err == nil {
+		t.Fatal("filter should be validated")
+	}
+}
@@ -0,0 +1,279 @@
+package pump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/spf13/cast"
+)
+
+const (
+	PropSyncStartFrom = "sync.start-from"
+
+	StartFromEarliest = "earliest"
+	StartFromLatest   = "latest"
+
+	// timeout of reading binlog events when scanning binlog files for the timestamp.
+	startFromScanTimeout = time.Second * 10
+)
+
+var (
+	startFromTimeFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+)
+
+func init() {
+	miso.SetDefProp(PropSyncStartFrom, StartFromLatest)
+}
+
+// Where to start when the binlog position is missing.
+type startFrom struct {
+	Raw      string
+	Earliest bool
+	Pos      mysql.Position // explicit position, file:pos
+	Time     time.Time      // the first transaction at or after the time
+}
+
+func (f startFrom) String() string {
+	if f.Raw == "" {
+		return StartFromLatest
+	}
+	return f.Raw
+}
+
+// Parse start-from, it can be 'earliest', 'latest', 'file:pos' or a timestamp, e.g., '2024-01-02 15:04:05' (local time) or RFC3339.
+func parseStartFrom(v string) (startFrom, error) {
+	v = strings.TrimSpace(v)
+	f := startFrom{Raw: v}
+	switch {
+	case v == "" || strings.EqualFold(v, StartFromLatest):
+		f.Raw = StartFromLatest
+		return f, nil
+	case strings.EqualFold(v, StartFromEarliest):
+		f.Earliest = true
+		return f, nil
+	}
+
+	for _, layout := range startFromTimeFormats {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			f.Time = t
+			return f, nil
+		}
+	}
+
+	pos, err := parseFilePos(v)
+	if err != nil {
+		return f, fmt.Errorf("'%v' is not one of '%v', '%v', 'file:pos' or timestamp", v, StartFromEarliest, StartFromLatest)
+	}
+	f.Pos = pos
+	return f, nil
+}
+
+// Initial binlog position determined by start-from.
+func (s *Source) initialPos(rail miso.Rail, sf startFrom) (BinlogPos, error) {
+	switch {
+	case sf.Pos.Name != "":
+		return BinlogPos{Position: sf.Pos}, nil
+
+	case !sf.Time.IsZero():
+		p, err := s.findPosByTime(rail, sf.Time)
+		if err != nil {
+			return BinlogPos{}, fmt.Errorf("failed to find binlog position at %v, %w", sf.Time, err)
+		}
+		return BinlogPos{Position: p}, nil
+
+	case sf.Earliest:
+		logs, err := s.FetchBinaryLogs(rail)
+		if err != nil {
+			return BinlogPos{}, err
+		}
+		if len(logs) < 1 {
+			return BinlogPos{}, errors.New("no binary log is found on master")
+		}
+		pos := BinlogPos{Position: mysql.Position{Name: logs[0].LogName, Pos: 4}}
+		if s.isGTIDMode() {
+			if pos.GTID, err = s.fetchPurgedGTIDSet(rail); err != nil {
+				return BinlogPos{}, err
+			}
+		}
+		return pos, nil
+
+	default:
+		ms, err := s.FetchMasterStatus(rail)
+		if err != nil {
+			rail.Warnf("Failed to fetch master status, %v", err)
+			return BinlogPos{}, err
+		}
+		pos := BinlogPos{Position: mysql.Position{Name: ms.File, Pos: cast.ToUint32(ms.Position)}}
+		if s.isGTIDMode() {
+			pos.GTID = ms.ExecutedGtidSet
+		}
+		return pos, nil
+	}
+}
+
+type BinaryLog struct {
+	LogName  string `gorm:"column:Log_name"`
+	FileSize int64  `gorm:"column:File_size"`
+}
+
+// Binlog files that are not purged yet, the earliest comes first.
+func (s *Source) FetchBinaryLogs(rail miso.Rail) ([]BinaryLog, error) {
+	var logs []BinaryLog
+	if err := s.conn.Raw(`SHOW BINARY LOGS`).Scan(&logs).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch binary logs, %w", err)
+	}
+	return logs, nil
+}
+
+// GTID set of the transactions that are purged from binlog, i.e., GTID set right before the earliest binlog.
+func (s *Source) fetchPurgedGTIDSet(rail miso.Rail) (string, error) {
+	if s.flavor() == flavorMariaDB {
+		// MariaDB starts from the earliest binlog when the GTID position is empty
+		return "", nil
+	}
+	var gtid string
+	if err := s.conn.Raw(`SELECT @@GLOBAL.gtid_purged`).Scan(&gtid).Error; err != nil {
+		return "", fmt.Errorf("failed to fetch gtid_purged, %w", err)
+	}
+	return gtid, nil
+}
+
+// Find position of the first transaction at or after the time.
+//
+// Binlog files are binary searched by the time they are created (the timestamp of FormatDescriptionEvent),
+// then the events in the file are scanned until the first transaction at or after the time is found.
+// If there is no such transaction, the latest position is returned.
+func (s *Source) findPosByTime(rail miso.Rail, t time.Time) (mysql.Position, error) {
+	logs, err := s.FetchBinaryLogs(rail)
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	if len(logs) < 1 {
+		return mysql.Position{}, errors.New("no binary log is found on master")
+	}
+	ms, err := s.FetchMasterStatus(rail)
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	latest := mysql.Position{Name: ms.File, Pos: cast.ToUint32(ms.Position)}
+
+	idx := 0
+	lo, hi := 0, len(logs)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		created, err := s.binlogFileCreateTime(rail, logs[mid].LogName)
+		if err != nil {
+			return mysql.Position{}, err
+		}
+		if created.After(t) {
+			hi = mid - 1
+		} else {
+			idx = mid
+			lo = mid + 1
+		}
+	}
+	rail.Infof("Scanning binlog events since %v for the first transaction at or after %v", logs[idx].LogName, t)
+	return s.scanPosByTime(rail, mysql.Position{Name: logs[idx].LogName, Pos: 4}, t, latest)
+}
+
+// Read binlog events since the position using a temporary BinlogSyncer.
+func (s *Source) scanBinlog(rail miso.Rail, from mysql.Position, f func(file string, ev *replication.BinlogEvent) (bool, error)) error {
+	syncer := replication.NewBinlogSyncer(s.syncerConf)
+	defer syncer.Close()
+
+	streamer, err := syncer.StartSync(from)
+	if err != nil {
+		return err
+	}
+	file := from.Name
+	for {
+		ctx, cancel := context.WithTimeout(rail.Context(), startFromScanTimeout)
+		ev, err := streamer.GetEvent(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if re, ok := ev.Event.(*replication.RotateEvent); ok {
+			file = string(re.NextLogName)
+		}
+		done, err := f(file, ev)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func (s *Source) binlogFileCreateTime(rail miso.Rail, file string) (time.Time, error) {
+	var created time.Time
+	err := s.scanBinlog(rail, mysql.Position{Name: file, Pos: 4}, func(_ string, ev *replication.BinlogEvent) (bool, error) {
+		if ev.Header.EventType != replication.FORMAT_DESCRIPTION_EVENT {
+			return false, nil
+		}
+		created = time.Unix(int64(ev.Header.Timestamp), 0)
+		return true, nil
+	})
+	if err != nil {
+		return created, fmt.Errorf("failed to read binlog file '%v', %w", file, err)
+	}
+	return created, nil
+}
+
+func (s *Source) scanPosByTime(rail miso.Rail, from mysql.Position, t time.Time, latest mysql.Position) (mysql.Position, error) {
+	var found mysql.Position
+	sc := txStartScanner{}
+	err := s.scanBinlog(rail, from, func(file string, ev *replication.BinlogEvent) (bool, error) {
+		switch ev.Header.EventType {
+		case replication.HEARTBEAT_EVENT, replication.HEARTBEAT_LOG_EVENT_V2:
+			found = latest // no more events
+			return true, nil
+		}
+		if start, ok := sc.feed(ev); ok && !time.Unix(int64(ev.Header.Timestamp), 0).Before(t) {
+			found = mysql.Position{Name: file, Pos: start}
+			return true, nil
+		}
+		if file == latest.Name && ev.Header.LogPos >= latest.Pos {
+			found = latest
+			return true, nil
+		}
+		return false, nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return latest, nil // no more events
+	}
+	return found, err
+}
+
+// Detect the beginning of transactions in binlog events.
+type txStartScanner struct {
+	afterGTID bool
+}
+
+// Returns the start position of the event if the event begins a transaction.
+func (sc *txStartScanner) feed(ev *replication.BinlogEvent) (uint32, bool) {
+	start := ev.Header.LogPos - ev.Header.EventSize
+	switch t := ev.Event.(type) {
+	case *replication.GTIDEvent, *replication.MariadbGTIDEvent:
+		sc.afterGTID = true
+		return start, true
+	case *replication.QueryEvent:
+		q := string(t.Query)
+		if isCommitQuery(q) {
+			sc.afterGTID = false
+			return 0, false
+		}
+		if sc.afterGTID { // 'BEGIN' or DDL of the transaction that begins with GTID event
+			sc.afterGTID = false
+			return 0, false
+		}
+		return start, true // 'BEGIN' or DDL without GTID event
+	case *replication.XIDEvent:
+		sc.afterGTID = false
+	}
+	return 0, false
+}
@@ -0,0 +1,80 @@
+package pump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestParseStartFrom(t *testing.T) {
+	f, err := parseStartFrom("")
+	if err != nil || f.Earliest || f.Pos.Name != "" || !f.Time.IsZero() || f.String() != StartFromLatest {
+		t.Fatalf("%+v, %v", f, err)
+	}
+	f, err = parseStartFrom("EARLIEST")
+	if err != nil || !f.Earliest {
+		t.Fatalf("%+v, %v", f, err)
+	}
+	f, err = parseStartFrom("binlog.000003:1234")
+	if err != nil || f.Pos != (mysql.Position{Name: "binlog.000003", Pos: 1234}) {
+		t.Fatalf("%+v, %v", f, err)
+	}
+	f, err = parseStartFrom("2024-01-02 15:04:05")
+	if err != nil || !f.Time.Equal(time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)) {
+		t.Fatalf("%+v, %v", f, err)
+	}
+	f, err = parseStartFrom("2024-01-02T15:04:05Z")
+	if err != nil || !f.Time.Equal(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Fatalf("%+v, %v", f, err)
+	}
+	if _, err = parseStartFrom("yesterday"); err == nil {
+		t.Fatal("should be invalid")
+	}
+}
+
+func TestStartFromGTIDMode(t *testing.T) {
+	conf := SourceConfig{Name: "test", Flavor: flavorMysql, GTIDEnabled: true, StartFrom: "binlog.000001:4"}
+	if _, err := NewSource(conf); err == nil {
+		t.Fatal("file:pos should be rejected in GTID mode")
+	}
+	conf.StartFrom = StartFromEarliest
+	if _, err := NewSource(conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxStartScanner(t *testing.T) {
+	ev := func(typ replication.EventType, e replication.Event, logPos uint32, size uint32) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: typ, LogPos: logPos, EventSize: size}, Event: e}
+	}
+	sc := txStartScanner{}
+	events := []struct {
+		ev    *replication.BinlogEvent
+		start uint32
+		ok    bool
+	}{
+		// transaction with GTID
+		{ev(replication.GTID_EVENT, &replication.GTIDEvent{}, 200, 70), 130, true},
+		{ev(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("BEGIN")}, 280, 80), 0, false},
+		{ev(replication.TABLE_MAP_EVENT, &replication.TableMapEvent{}, 330, 50), 0, false},
+		{ev(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{}, 400, 70), 0, false},
+		{ev(replication.XID_EVENT, &replication.XIDEvent{}, 431, 31), 0, false},
+
+		// transaction without GTID
+		{ev(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("BEGIN")}, 511, 80), 431, true},
+		{ev(replication.TABLE_MAP_EVENT, &replication.TableMapEvent{}, 561, 50), 0, false},
+		{ev(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{}, 631, 70), 0, false},
+		{ev(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("COMMIT")}, 711, 80), 0, false},
+
+		// DDL without GTID
+		{ev(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("alter table t add column c int")}, 811, 100), 711, true},
+	}
+	for i, v := range events {
+		start, ok := sc.feed(v.ev)
+		if start != v.start || ok != v.ok {
+			t.Fatalf("[%d] expected: %v, %v, actual: %v, %v", i, v.start, v.ok, start, ok)
+		}
+	}
+}
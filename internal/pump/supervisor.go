@@ -0,0 +1,126 @@
+package pump
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	PropSyncRestartMaxAttempts    = "sync.restart.max-attempts"
+	PropSyncRestartInitialBackoff = "sync.restart.initial-backoff"
+	PropSyncRestartMaxBackoff     = "sync.restart.max-backoff"
+
+	// stream that has been running for a while is considered recovered, the consecutive failures are reset.
+	restartStableDuration = time.Minute * 5
+)
+
+func init() {
+	miso.SetDefProp(PropSyncRestartMaxAttempts, 10)
+	miso.SetDefProp(PropSyncRestartInitialBackoff, 1)
+	miso.SetDefProp(PropSyncRestartMaxBackoff, 60)
+}
+
+// Pump events, and restart the stream with exponential backoff when PumpEvents returns error.
+//
+// The error is returned when the stream can't be recovered after `sync.restart.max-attempts` consecutive attempts.
+func (s *Source) SuperviseStream(rail miso.Rail) error {
+	maxAttempts := miso.GetPropInt(PropSyncRestartMaxAttempts)
+	initialBackoff := time.Duration(miso.GetPropInt(PropSyncRestartInitialBackoff)) * time.Second
+	maxBackoff := time.Duration(miso.GetPropInt(PropSyncRestartMaxBackoff)) * time.Second
+
+	failures := 0
+	for {
+		start := time.Now()
+		err := s.PumpEvents(rail)
+		if err == nil {
+			return nil
+		}
+		if time.Since(start) > restartStableDuration {
+			failures = 0
+		}
+
+		for {
+			failures++
+			if maxAttempts > 0 && failures > maxAttempts {
+				rail.Errorf("Stream of source '%v' failed %v times consecutively, give up", s.Name, failures-1)
+				return err
+			}
+
+			backoff := restartBackoff(failures, initialBackoff, maxBackoff)
+			rail.Errorf("Stream of source '%v' encountered error: %v, restarting in %v (attempt: %v)", s.Name, err, backoff, failures)
+			select {
+			case <-rail.Context().Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			if miso.IsShuttingDown() {
+				return nil
+			}
+
+			streamRestartCounter.WithLabelValues(s.Name).Inc()
+			if err = s.restartStream(rail); err == nil {
+				rail.Infof("Stream of source '%v' restarted", s.Name)
+				break
+			}
+			streamRestartFailureCounter.WithLabelValues(s.Name).Inc()
+		}
+	}
+}
+
+// Close the BinlogSyncer, and rebuild it from the last flushed position.
+func (s *Source) restartStream(rail miso.Rail) error {
+	s.closeSync()
+
+	// pos is only moved at the boundary of transactions, the incomplete transaction is replayed
+	s.FlushPos()
+	s.abortTx(rail)
+	s.tableInfoMap = make(map[string]TableInfo)
+	atomic.StoreInt32(&s.resyncErrCount, 0)
+
+	return s.doStartStreamFunc(rail)
+}
+
+// Connect to the master instance and start streaming from the stored position.
+func (s *Source) startStream(rail miso.Rail) error {
+	if _, err := s.PrepareSync(rail); err != nil {
+		return err
+	}
+	if err := s.checkSchemaHistoryServer(rail); err != nil {
+		return err
+	}
+	if _, err := s.NewStreamer(rail); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close BinlogSyncer and the connection to the master instance.
+func (s *Source) closeSync() {
+	if s.syncer != nil {
+		s.syncer.Close()
+		s.syncer = nil
+	}
+	if s.conn != nil {
+		if db, err := s.conn.DB(); err == nil {
+			db.Close()
+		}
+		s.conn = nil
+	}
+}
+
+// Exponential backoff, initial * 2^(attempt-1), capped by max.
+func restartBackoff(attempt int, initial time.Duration, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
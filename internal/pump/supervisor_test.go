@@ -0,0 +1,90 @@
+package pump
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	initial := time.Second
+	max := time.Second * 60
+	tab := []struct {
+		attempt int
+		backoff time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, time.Second * 2},
+		{3, time.Second * 4},
+		{6, time.Second * 32},
+		{7, time.Second * 60},
+		{100, time.Second * 60},
+	}
+	for _, v := range tab {
+		if b := restartBackoff(v.attempt, initial, max); b != v.backoff {
+			t.Fatalf("attempt: %v, expected: %v, actual: %v", v.attempt, v.backoff, b)
+		}
+	}
+}
+
+func TestAbortTx(t *testing.T) {
+	s := newTestSource(t, flavorMysql)
+	rail := miso.EmptyRail()
+
+	var aborted string
+	handlerId := OnEventReceived(func(c miso.Rail, dce DataChangeEvent, ctx *EventHandleContext) error { return nil })
+	defer RemoveEventHandler(handlerId)
+	OnTxAborted(handlerId, func(c miso.Rail, source string) { aborted = source })
+
+	s.currTx.begin("binlog.000001:4", mysql.Position{Name: "binlog.000001", Pos: 4}, 0)
+	s.abortTx(rail)
+	if s.isInTx() {
+		t.Fatal("transaction should be discarded")
+	}
+	if aborted != s.Name {
+		t.Fatalf("TxAbortHandler not called, %v", aborted)
+	}
+}
+
+func TestRestartStreamLocalPosFile(t *testing.T) {
+	s := newTestSource(t, flavorMysql)
+	s.conf.PosFile = filepath.Join(t.TempDir(), "binlog_pos")
+	rail := miso.EmptyRail()
+	if err := s.doAttachPosFunc(rail); err != nil {
+		t.Fatal(err)
+	}
+	defer s.doDetachPosFunc(rail)
+
+	var restarted []BinlogPos
+	s.doStartStreamFunc = func(rail miso.Rail) error {
+		byt, err := s.doReadPosFunc(rail)
+		if err != nil {
+			return err
+		}
+		pos, err := parseBinlogPos(string(byt))
+		if err != nil {
+			return err
+		}
+		restarted = append(restarted, pos)
+		return nil
+	}
+
+	positions := []BinlogPos{
+		{Position: mysql.Position{Name: "binlog.000001", Pos: 4}},
+		{Position: mysql.Position{Name: "binlog.000001", Pos: 123456789}},
+		{Position: mysql.Position{Name: "binlog.000002", Pos: 4}}, // shorter than the previous one
+	}
+	for i, p := range positions {
+		s.nextPos = p
+		if err := s.restartStream(rail); err != nil {
+			t.Fatal(err)
+		}
+		if len(restarted) != i+1 || restarted[i] != p {
+			t.Fatalf("expected: %+v, restarted: %+v", p, restarted)
+		}
+	}
+}
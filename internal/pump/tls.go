@@ -0,0 +1,80 @@
+package pump
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	driver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	PropSyncTLSEnabled    = "sync.tls.enabled"
+	PropSyncTLSCAFile     = "sync.tls.ca-file"
+	PropSyncTLSCertFile   = "sync.tls.cert-file"
+	PropSyncTLSKeyFile    = "sync.tls.key-file"
+	PropSyncTLSServerName = "sync.tls.server-name"
+	PropSyncTLSSkipVerify = "sync.tls.skip-verify"
+
+	// prefix of the tls config name registered in go-sql-driver, see driver.RegisterTLSConfig().
+	driverTLSConfigPrefix = "event-pump-"
+)
+
+func init() {
+	miso.SetDefProp(PropSyncTLSEnabled, false)
+	miso.SetDefProp(PropSyncTLSSkipVerify, false)
+}
+
+// Build tls.Config for connections to the master instance, nil is returned if TLS is disabled.
+func buildTLSConfig(conf TLSConfig, host string) (*tls.Config, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         strings.TrimSpace(conf.ServerName),
+		InsecureSkipVerify: conf.SkipVerify,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = strings.TrimSpace(host)
+	}
+
+	if caFile := strings.TrimSpace(conf.CAFile); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: '%v', %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse CA file: '%v', no valid certificate found", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	certFile := strings.TrimSpace(conf.CertFile)
+	keyFile := strings.TrimSpace(conf.KeyFile)
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both client certificate file and key file must be specified")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate, %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Register the tls.Config in go-sql-driver, returns the connection param that should be appended to the DSN.
+func registerDriverTLSConfig(source string, cfg *tls.Config) (string, error) {
+	name := driverTLSConfigPrefix + source
+	if err := driver.RegisterTLSConfig(name, cfg.Clone()); err != nil {
+		return "", err
+	}
+	return "tls=" + url.QueryEscape(name), nil
+}
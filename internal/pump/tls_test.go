@@ -0,0 +1,94 @@
+package pump
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	// self-signed certificate, used as both the CA and the client certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "event-pump"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := write("cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyFile := write("key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	invalidFile := write("invalid.pem", []byte("not a certificate"))
+	missingFile := filepath.Join(dir, "missing.pem")
+
+	tab := []struct {
+		name       string
+		conf       TLSConfig
+		nilConf    bool
+		ok         bool
+		serverName string
+		skipVerify bool
+		rootCAs    bool
+		certs      int
+	}{
+		{name: "disabled", conf: TLSConfig{CAFile: missingFile}, nilConf: true, ok: true},
+		{name: "default server name", conf: TLSConfig{Enabled: true}, ok: true, serverName: "db.example.com"},
+		{name: "server name", conf: TLSConfig{Enabled: true, ServerName: " mysql.internal "}, ok: true, serverName: "mysql.internal"},
+		{name: "skip verify", conf: TLSConfig{Enabled: true, SkipVerify: true}, ok: true, serverName: "db.example.com", skipVerify: true},
+		{name: "ca file", conf: TLSConfig{Enabled: true, CAFile: certFile}, ok: true, serverName: "db.example.com", rootCAs: true},
+		{name: "client certificate", conf: TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}, ok: true, serverName: "db.example.com", certs: 1},
+		{name: "cert without key", conf: TLSConfig{Enabled: true, CertFile: certFile}},
+		{name: "key without cert", conf: TLSConfig{Enabled: true, KeyFile: keyFile}},
+		{name: "invalid client certificate", conf: TLSConfig{Enabled: true, CertFile: invalidFile, KeyFile: keyFile}},
+		{name: "unreadable ca file", conf: TLSConfig{Enabled: true, CAFile: missingFile}},
+		{name: "invalid ca file", conf: TLSConfig{Enabled: true, CAFile: invalidFile}},
+	}
+	for _, v := range tab {
+		cfg, err := buildTLSConfig(v.conf, " db.example.com ")
+		if (err == nil) != v.ok {
+			t.Fatalf("%v, err: %v", v.name, err)
+		}
+		if !v.ok {
+			continue
+		}
+		if v.nilConf {
+			if cfg != nil {
+				t.Fatalf("%v, tls.Config should be nil", v.name)
+			}
+			continue
+		}
+		if cfg.ServerName != v.serverName || cfg.InsecureSkipVerify != v.skipVerify ||
+			(cfg.RootCAs != nil) != v.rootCAs || len(cfg.Certificates) != v.certs {
+			t.Fatalf("%v, %+v", v.name, cfg)
+		}
+	}
+}
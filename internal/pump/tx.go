@@ -0,0 +1,147 @@
+package pump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util/hash"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Transaction that is being processed.
+//
+// For MySQL, a transaction normally looks like: [GTIDEvent] -> QueryEvent 'BEGIN' -> TableMapEvent -> RowsEvent ... -> XIDEvent,
+// DDL is written as a single QueryEvent (preceded by GTIDEvent). For MariaDB, the 'BEGIN' QueryEvent is omitted.
+type txState struct {
+	Id        string // GTID if available, otherwise binlog position of the first event of the transaction, i.e., file:pos
+	GTID      string // GTID of the transaction, only available if the transaction begins with GTID event
+	Seq       int    // number of records in the transaction so far
+	Active    bool
+	SawBegin  bool // whether 'BEGIN' QueryEvent is received
+	Timestamp uint32
+	Pos       mysql.Position // binlog position of the first event of the transaction
+
+	// handlers of the pipelines that have received the changes of the transaction in snapshot, nil if not resolved yet.
+	snapshotted *hash.Set[string]
+}
+
+// Committed transaction.
+type TxInfo struct {
+	Source    string
+	Id        string
+	Timestamp uint32
+	Records   int
+}
+
+// Track the beginning of transaction, it should be called before the event is handled.
+func (s *Source) beginTx(rail miso.Rail, ev *replication.BinlogEvent) {
+	switch t := ev.Event.(type) {
+	case *replication.GTIDEvent:
+		if ev.Header.EventType == replication.ANONYMOUS_GTID_EVENT {
+			return
+		}
+		next, err := t.GTIDNext()
+		if err != nil {
+			rail.Errorf("Failed to parse GTID, %v", err)
+			return
+		}
+		s.currTx.begin(next.String(), s.posOfEvent(ev), ev.Header.Timestamp)
+		s.currTx.GTID = s.currTx.Id
+	case *replication.MariadbGTIDEvent:
+		s.currTx.begin(t.GTID.String(), s.posOfEvent(ev), ev.Header.Timestamp)
+		s.currTx.GTID = s.currTx.Id
+	case *replication.QueryEvent:
+		q := string(t.Query)
+		if !s.currTx.Active && !isCommitQuery(q) {
+			// DDL is implicitly a transaction
+			s.currTx.begin(s.txIdOfEvent(ev), s.posOfEvent(ev), ev.Header.Timestamp)
+		}
+		if isBeginQuery(q) {
+			s.currTx.SawBegin = true
+		}
+	case *replication.RowsEvent:
+		if !s.currTx.Active {
+			// transaction began before we start streaming, or the 'BEGIN' is missing
+			s.currTx.begin(s.txIdOfEvent(ev), s.posOfEvent(ev), ev.Header.Timestamp)
+		}
+	}
+}
+
+// Track the end of transaction, it should be called after the event is handled.
+//
+// Returns true if the transaction is ended.
+func (s *Source) commitTx(rail miso.Rail, ev *replication.BinlogEvent) (bool, error) {
+	if !s.currTx.Active {
+		return false, nil
+	}
+
+	switch t := ev.Event.(type) {
+	case *replication.XIDEvent:
+	case *replication.QueryEvent:
+		q := string(t.Query)
+		if isBeginQuery(q) {
+			return false, nil
+		}
+		if !isCommitQuery(q) && (s.currTx.SawBegin || s.currTx.Seq > 0) {
+			return false, nil // e.g., SAVEPOINT within the transaction
+		}
+	default:
+		return false, nil
+	}
+
+	tx := TxInfo{Source: s.Name, Id: s.currTx.Id, Timestamp: s.currTx.Timestamp, Records: s.currTx.Seq}
+	snapshotted := s.snapshottedHandlers(rail)
+	s.currTx = txState{}
+	rail.Debugf("Transaction committed, %+v", tx)
+	return true, callTxCommitHandlersExcept(rail, tx, snapshotted)
+}
+
+// Assign transaction id, sequence and GTID to the DataChangeEvent.
+func (s *Source) assignTx(ev *replication.BinlogEvent, dce *DataChangeEvent) {
+	if !s.currTx.Active {
+		s.currTx.begin(s.txIdOfEvent(ev), s.posOfEvent(ev), ev.Header.Timestamp)
+	}
+	dce.TxId = s.currTx.Id
+	dce.TxSeq = s.currTx.Seq
+	dce.GTID = s.currTx.GTID
+	s.currTx.Seq += len(dce.Records)
+}
+
+// Discard the incomplete transaction, it's called before the stream is restarted.
+func (s *Source) abortTx(rail miso.Rail) {
+	if s.currTx.Active {
+		rail.Infof("Discarded incomplete transaction '%v' of source '%v'", s.currTx.Id, s.Name)
+	}
+	s.currTx = txState{}
+	callTxAbortHandlers(rail, s.Name)
+}
+
+func (s *Source) isInTx() bool {
+	return s.currTx.Active
+}
+
+func (t *txState) begin(id string, pos mysql.Position, timestamp uint32) {
+	*t = txState{Id: id, Active: true, Timestamp: timestamp, Pos: pos}
+}
+
+func (s *Source) txIdOfEvent(ev *replication.BinlogEvent) string {
+	p := s.posOfEvent(ev)
+	return fmt.Sprintf("%s:%d", p.Name, p.Pos)
+}
+
+// Start position of the event.
+func (s *Source) posOfEvent(ev *replication.BinlogEvent) mysql.Position {
+	return mysql.Position{Name: s.currentBinlogFile(), Pos: ev.Header.LogPos - ev.Header.EventSize}
+}
+
+func isBeginQuery(q string) bool {
+	return strings.EqualFold(strings.TrimSpace(q), "BEGIN")
+}
+
+// COMMIT or ROLLBACK, the latter is written for transaction with changes to non-transactional tables.
+func isCommitQuery(q string) bool {
+	q = strings.TrimSpace(q)
+	return strings.EqualFold(q, "COMMIT") || strings.EqualFold(q, "ROLLBACK")
+}
@@ -0,0 +1,64 @@
+package pump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+const (
+	// MySQL 8.2 introduced SHOW BINARY LOG STATUS, SHOW MASTER STATUS is removed in MySQL 8.4.
+	mysqlVersionBinaryLogStatus = "8.2.0"
+)
+
+// Detect version of the master instance, e.g., '8.0.36', '8.4.0', '10.11.6-MariaDB'.
+func (s *Source) detectServerVersion(rail miso.Rail) error {
+	var v string
+	if err := s.conn.Raw(`SELECT VERSION()`).Scan(&v).Error; err != nil {
+		return fmt.Errorf("failed to detect server version of source '%v', %w", s.Name, err)
+	}
+	s.serverVersion = v
+	rail.Infof("Server version of source '%v': %v", s.Name, v)
+	return nil
+}
+
+// Identity of the master instance, server_uuid for MySQL, server_id for MariaDB.
+func (s *Source) detectServerIdentity() (string, error) {
+	q := `SELECT @@server_uuid`
+	if s.flavor() == flavorMariaDB {
+		q = `SELECT CAST(@@server_id AS CHAR)`
+	}
+	var v string
+	if err := s.conn.Raw(q).Scan(&v).Error; err != nil {
+		return "", fmt.Errorf("failed to detect server identity of source '%v', %w", s.Name, err)
+	}
+	return v, nil
+}
+
+// Statement that shows the current binlog position of master.
+func masterStatusStmt(flavor string, version string) string {
+	if flavor == flavorMysql && versionAtLeast(version, mysqlVersionBinaryLogStatus) {
+		return `SHOW BINARY LOG STATUS`
+	}
+	return `SHOW MASTER STATUS`
+}
+
+// Whether the server version is at least the specified version, suffix like '-log' is ignored.
+//
+// False is returned if the version is unknown.
+func versionAtLeast(version string, min string) bool {
+	version = strings.TrimSpace(version)
+	if i := strings.IndexAny(version, "-+ "); i > -1 {
+		version = version[:i]
+	}
+	if version == "" {
+		return false
+	}
+	c, err := mysql.CompareServerVersions(version, min)
+	if err != nil {
+		return false
+	}
+	return c >= 0
+}
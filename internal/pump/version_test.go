@@ -0,0 +1,26 @@
+package pump
+
+import "testing"
+
+func TestMasterStatusStmt(t *testing.T) {
+	tab := []struct {
+		flavor   string
+		version  string
+		expected string
+	}{
+		{flavorMysql, "5.7.44-log", "SHOW MASTER STATUS"},
+		{flavorMysql, "8.0.36", "SHOW MASTER STATUS"},
+		{flavorMysql, "8.0.36-0ubuntu0.22.04.1", "SHOW MASTER STATUS"},
+		{flavorMysql, "8.2.0", "SHOW BINARY LOG STATUS"},
+		{flavorMysql, "8.4.0-log", "SHOW BINARY LOG STATUS"},
+		{flavorMysql, "9.1.0", "SHOW BINARY LOG STATUS"},
+		{flavorMysql, "", "SHOW MASTER STATUS"},
+		{flavorMariaDB, "10.11.6-MariaDB", "SHOW MASTER STATUS"},
+		{flavorMariaDB, "11.4.2-MariaDB-log", "SHOW MASTER STATUS"},
+	}
+	for _, v := range tab {
+		if s := masterStatusStmt(v.flavor, v.version); s != v.expected {
+			t.Fatalf("flavor: %v, version: %v, expected: %v, actual: %v", v.flavor, v.version, v.expected, s)
+		}
+	}
+}
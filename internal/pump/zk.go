@@ -3,6 +3,8 @@ package pump
 import (
 	"errors"
 	"fmt"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,8 +25,24 @@ const (
 	ZkPathRoot   = "/eventpump"
 	ZkPathLeader = ZkPathRoot + "/leader"
 	ZkPathPos    = ZkPathRoot + "/pos"
+
+	ZkPathSchemaHistory = ZkPathRoot + "/schema-history"
+	ZkPathSnapshotState = ZkPathRoot + "/snapshot-state"
+
+	// nodes of sources other than the default one, e.g., /eventpump/sources/${name}/pos
+	ZkPathSources = ZkPathRoot + "/sources"
 )
 
+// Path of the node that belongs to the source.
+//
+// For backward compatibility, nodes of the default source are kept at their original paths.
+func (s *Source) zkPath(p string) string {
+	if s.isDefault() {
+		return p
+	}
+	return ZkPathSources + "/" + s.Name + strings.TrimPrefix(p, ZkPathRoot)
+}
+
 func ConnZk() *zk.Conn {
 	zkOnce.Do(func() {
 		hosts := miso.GetPropStrSlice(PropHost)
@@ -65,16 +83,23 @@ func ZkGet(p string) ([]byte, error) {
 	return buf, err
 }
 
-func ZkWritePos(buf []byte) error {
-	_, err := ConnZk().Set(ZkPathPos, buf, -1)
+// Write data to the node, the node and its parents are created if necessary.
+func ZkWrite(p string, buf []byte) error {
+	_, err := ConnZk().Set(p, buf, -1)
 	if err != nil && errors.Is(err, zk.ErrNoNode) {
-		return ZkCreatePer(ZkPathPos, buf)
+		if parent := path.Dir(p); parent != "/" && parent != ZkPathRoot {
+			if err := ZkWrite(parent, nil); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+				return err
+			}
+		}
+		return ZkCreatePer(p, buf)
 	}
 	return err
 }
 
-func ZkReadPos() ([]byte, error) {
-	buf, err := ZkGet(ZkPathPos)
+// Read data of the node, nil is returned if the node doesn't exist.
+func ZkRead(p string) ([]byte, error) {
+	buf, err := ZkGet(p)
 	if err != nil && errors.Is(err, zk.ErrNoNode) {
 		return nil, nil
 	}